@@ -0,0 +1,210 @@
+// Package collector provides Prometheus collectors for Hadoop daemons that
+// other programs can register with their own registry:
+//
+//	nn, err := collector.NewNameNode(collector.NameNodeOptions{
+//		Options: collector.Options{URL: "http://namenode:50070/jmx"},
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	prometheus.MustRegister(nn)
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+// Options configure the collector of a daemon.
+type Options struct {
+	// URL is the address of the daemon. What it points to depends on the
+	// daemon, see NameNodeOptions and NewResourceManager.
+	URL string
+	// Client is used for requests to the daemon. http.DefaultClient is used
+	// if nil; scrape.NewTransport returns a client suited to daemons.
+	Client *http.Client
+	// Labels are added to every metric of the collector, e.g.
+	// {"cluster": "prod"}.
+	Labels prometheus.Labels
+	// Collectors are the names of the sub-collectors to enable. The default
+	// sub-collectors of the daemon are enabled if nil, none if empty.
+	Collectors []string
+	// LegacyNames makes the collector export its metrics also under the
+	// names earlier versions used, e.g. namenode_CapacityTotal besides
+	// namenode_capacity_bytes, for dashboards that still use them.
+	LegacyNames bool
+}
+
+func (o Options) client() *http.Client {
+	if o.Client == nil {
+		return http.DefaultClient
+	}
+	return o.Client
+}
+
+// SubCollector describes a part of the metrics of a daemon that can be
+// enabled separately, such as the JVM metrics of the NameNode.
+type SubCollector struct {
+	Name        string
+	Description string
+	// Default reports whether the sub-collector is enabled if
+	// Options.Collectors is nil.
+	Default bool
+}
+
+// enabled returns the names of the sub-collectors named by o.Collectors, or
+// of the default ones among available if o.Collectors is nil.
+func (o Options) enabled(available []SubCollector) ([]string, error) {
+	var names, defaults []string
+	known := map[string]bool{}
+	for _, c := range available {
+		names = append(names, c.Name)
+		known[c.Name] = true
+		if c.Default {
+			defaults = append(defaults, c.Name)
+		}
+	}
+	if o.Collectors == nil {
+		return defaults, nil
+	}
+	for _, name := range o.Collectors {
+		if !known[name] {
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown collector %q, choose from %s", name, strings.Join(names, ", "))
+		}
+	}
+	return o.Collectors, nil
+}
+
+// subCollector is an enabled sub-collector. Its outcome is reported as
+// that of each of the collectors called names, usually one.
+type subCollector struct {
+	names   []string
+	collect scrape.CollectFunc
+}
+
+// daemon collects from a daemon on every scrape, sharing the fetch between
+// concurrent scrapes, or serves scrapes from a background poll.
+type daemon struct {
+	namespace  string
+	url        string
+	collectors []subCollector
+	metrics    *scrape.Metrics
+	poller     *scrape.Poller
+	stop       context.CancelFunc
+	group      scrape.Group
+}
+
+func newDaemon(namespace, url string, labels prometheus.Labels) *daemon {
+	return &daemon{
+		namespace: namespace,
+		url:       url,
+		metrics:   scrape.NewMetrics(namespace, labels),
+	}
+}
+
+// add adds an enabled sub-collector.
+func (d *daemon) add(name string, collect scrape.CollectFunc) {
+	d.addShared([]string{name}, collect)
+}
+
+// addShared adds a sub-collector doing the work of the enabled collectors
+// called names at once, e.g. fetching beans they all read.
+func (d *daemon) addShared(names []string, collect scrape.CollectFunc) {
+	d.collectors = append(d.collectors, subCollector{names, collect})
+}
+
+// collect runs the sub-collectors concurrently and sends the duration and
+// outcome of each along with their metrics. A sub-collector failing only
+// shows in its success metric; collect fails if all of them do, which
+// usually means the daemon is down. Their requests count as a single scrape
+// for the circuit breaker, see scrape.WithScrape.
+func (d *daemon) collect(ctx context.Context, ch chan<- prometheus.Metric) error {
+	ctx = scrape.WithScrape(ctx)
+	errs := make([]error, len(d.collectors))
+	var wg sync.WaitGroup
+	for i, c := range d.collectors {
+		wg.Add(1)
+		go func(i int, c subCollector) {
+			defer wg.Done()
+			start := time.Now()
+			errs[i] = c.collect(ctx, ch)
+			duration := time.Since(start)
+			for _, name := range c.names {
+				d.metrics.SendCollector(ch, name, duration, errs[i])
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	// Requests rejected because the failure of another sub-collector
+	// opened the circuit breaker hide why the scrape failed.
+	for _, err := range errs {
+		if !circuitOpen(err) {
+			return err
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// circuitOpen reports whether err means that a request was not sent
+// because the circuit breaker of the daemon is open.
+func circuitOpen(err error) bool {
+	var e *scrape.Error
+	return errors.As(err, &e) && e.Phase == scrape.PhaseCircuitOpen
+}
+
+func (d *daemon) describe(ch chan<- *prometheus.Desc) {
+	d.metrics.Describe(ch)
+	if d.poller != nil {
+		d.poller.Describe(ch)
+	}
+}
+
+func (d *daemon) collectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	if d.poller != nil {
+		d.poller.Collect(ch)
+		return
+	}
+	start := time.Now()
+	metrics, shared, err := d.group.Do(ctx, d.url, d.collect)
+	for _, m := range metrics {
+		ch <- m
+	}
+	if shared {
+		// The scrape that did the fetch records its outcome.
+		d.metrics.Shared()
+		d.metrics.Send(ch, time.Since(start), err)
+	} else {
+		d.metrics.Collect(ch, start, err)
+	}
+}
+
+func (d *daemon) startPolling(interval time.Duration) {
+	var ctx context.Context
+	ctx, d.stop = context.WithCancel(context.Background())
+	d.poller = scrape.NewPoller(d.namespace, d.metrics, interval, d.collect)
+	go d.poller.Run(ctx)
+}
+
+func (d *daemon) stopPolling() {
+	if d.stop != nil {
+		d.stop()
+	}
+}
@@ -0,0 +1,119 @@
+package collector
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+// newMetricsServer serves the metrics of cs, registered with a new
+// registry.
+func newMetricsServer(cs ...prometheus.Collector) *httptest.Server {
+	registry := prometheus.NewRegistry()
+	for _, c := range cs {
+		registry.MustRegister(c)
+	}
+	return httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
+
+// expectMetrics scrapes cs once and reports the lines of want missing from
+// the exposition and the strings of absent found in it. It returns the
+// exposition for further checks.
+func expectMetrics(t *testing.T, want, absent []string, cs ...prometheus.Collector) string {
+	t.Helper()
+	server := newMetricsServer(cs...)
+	defer server.Close()
+	body, err := get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range want {
+		if !strings.Contains(body, w) {
+			t.Errorf("missing %s:\n%s", w, body)
+		}
+	}
+	for _, a := range absent {
+		if strings.Contains(body, a) {
+			t.Errorf("unexpected %s:\n%s", a, body)
+		}
+	}
+	return body
+}
+
+func get(url string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// TestOptions registers two NameNode collectors that differ only in their
+// labels with one registry, as an embedding program would.
+func TestOptions(t *testing.T) {
+	namenode := newNameNode()
+	defer namenode.Close()
+
+	var cs []prometheus.Collector
+	for _, cluster := range []string{"a", "b"} {
+		nn, err := NewNameNode(NameNodeOptions{Options: Options{
+			URL:        namenode.URL + "/jmx",
+			Labels:     prometheus.Labels{"cluster": cluster},
+			Collectors: []string{"jvm"},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs = append(cs, nn)
+	}
+	expectMetrics(t, []string{
+		`namenode_up{cluster="a"} 1`,
+		`namenode_up{cluster="b"} 1`,
+		`namenode_jvm_memory_heap_used_bytes{cluster="a"} 1.24571464e+08`,
+		`namenode_jvm_memory_heap_used_bytes{cluster="b"} 1.24571464e+08`,
+	}, []string{
+		// The fsnamesystem collector is disabled.
+		"namenode_active",
+	}, cs...)
+
+	if _, err := NewResourceManager(Options{Collectors: []string{"queues"}}); err == nil {
+		t.Error("unknown collector accepted")
+	}
+}
+
+// TestCircuitOpenPhase fails a scrape whose first failed request opens the
+// circuit breaker, so that the other sub-collectors' requests are rejected,
+// and expects the scrape to count as failed by the HTTP status.
+func TestCircuitOpenPhase(t *testing.T) {
+	namenode := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}))
+	defer namenode.Close()
+
+	transport := scrape.NewTransport("namenode", nil, scrape.Options{
+		ReadTimeout:     time.Second,
+		BreakerFailures: 1,
+		BreakerCooldown: time.Minute,
+	})
+	nn, err := NewNameNode(NameNodeOptions{Options: Options{
+		URL:    namenode.URL + "/jmx",
+		Client: transport.Client(),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, []string{
+		`namenode_up 0`,
+		`namenode_scrape_errors_total{phase="http_status"} 1`,
+		`namenode_scrape_errors_total{phase="circuit_open"} 0`,
+	}, nil, nn)
+}
@@ -0,0 +1,241 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+	"github.com/wyukawa/hadoop_exporter/jmx"
+)
+
+const nameNodeInfoBean = "Hadoop:service=NameNode,name=NameNodeInfo"
+
+/*
+	{
+		"name" : "Hadoop:service=NameNode,name=NameNodeInfo",
+		"modelerType" : "org.apache.hadoop.hdfs.server.namenode.FSNamesystem",
+		"Threads" : 45,
+		"ClusterId" : "CID-ba2e3e5c-4c5e-4a2b-8f3a-6f0c2d7b1e9a",
+		"BlockPoolId" : "BP-1170815839-10.0.0.1-1456089162512",
+		"LiveNodes" : "{\"dn1.example.com:50010\":{\"infoAddr\":\"10.0.0.2:50075\",\"xferaddr\":\"10.0.0.2:50010\",\"lastContact\":1,\"usedSpace\":490430464,\"adminState\":\"In Service\",...}}",
+		"DeadNodes" : "{}",
+		"DecomNodes" : "{}",
+		...
+	}
+*/
+
+var errNotString = errors.New("not a string")
+
+// nameNodeInfo is the NameNodeInfo bean of a scrape, which is handed to
+// each enabled sub-collector reading it.
+type nameNodeInfo struct {
+	bean    jmx.Bean
+	decoded map[string]decodedDataNodes
+}
+
+type decodedDataNodes struct {
+	nodes map[string]map[string]interface{}
+	err   error
+}
+
+// infoCollectFunc collects the metrics of a sub-collector from the
+// NameNodeInfo bean.
+type infoCollectFunc func(info *nameNodeInfo, ch chan<- prometheus.Metric)
+
+// infoCollector is a sub-collector reading the NameNodeInfo bean.
+type infoCollector struct {
+	name    string
+	collect infoCollectFunc
+}
+
+// addInfoSection adds a sub-collector fetching the NameNodeInfo bean once
+// per scrape for all of infos, if any, and reports whether it did.
+func (j *JMX) addInfoSection(infos []infoCollector) bool {
+	if len(infos) == 0 {
+		return false
+	}
+	var names []string
+	for _, c := range infos {
+		names = append(names, c.name)
+	}
+	j.addSharedSection(names, section{
+		queries: []string{nameNodeInfoBean},
+		want:    func(name string) bool { return name == nameNodeInfoBean },
+		collect: func(beans []jmx.Bean, ch chan<- prometheus.Metric) {
+			for _, bean := range beans {
+				if bean.Name != nameNodeInfoBean {
+					continue
+				}
+				info := &nameNodeInfo{bean: bean, decoded: map[string]decodedDataNodes{}}
+				for _, c := range infos {
+					c.collect(info, ch)
+				}
+			}
+		},
+	})
+	return true
+}
+
+// dataNodes returns the DataNodes listed by an attribute of the bean,
+// decoding it on first use.
+func (i *nameNodeInfo) dataNodes(attribute string) (map[string]map[string]interface{}, error) {
+	d, ok := i.decoded[attribute]
+	if !ok {
+		d.nodes, d.err = decodeDataNodes(i.bean.Attributes[attribute])
+		if d.err != nil {
+			log.Errorf("Error decoding %s of %s: %s", attribute, i.bean.Name, d.err)
+		}
+		i.decoded[attribute] = d
+	}
+	return d.nodes, d.err
+}
+
+// dataNodeStates are the attributes of the NameNodeInfo bean listing the
+// DataNodes in each state.
+var dataNodeStates = []struct {
+	attribute, state string
+}{
+	{"LiveNodes", "live"},
+	{"DeadNodes", "dead"},
+	{"DecomNodes", "decommissioning"},
+}
+
+// dataNodes collects the DataNodes known to the NameNode from the
+// NameNodeInfo bean, whose attributes hold them as JSON objects keyed by
+// DataNode.
+type dataNodes struct {
+	count *prometheus.Desc
+}
+
+func newDataNodes(o NameNodeOptions) infoCollectFunc {
+	d := &dataNodes{
+		count: prometheus.NewDesc(
+			prometheus.BuildFQName(nameNodeNamespace, "", "datanodes"),
+			"Number of DataNodes by state.",
+			[]string{"state"}, o.Labels,
+		),
+	}
+	return d.collect
+}
+
+func (d *dataNodes) collect(info *nameNodeInfo, ch chan<- prometheus.Metric) {
+	for _, s := range dataNodeStates {
+		nodes, err := info.dataNodes(s.attribute)
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(d.count, prometheus.GaugeValue, float64(len(nodes)), s.state)
+	}
+}
+
+// decodeDataNodes decodes an attribute of the NameNodeInfo bean listing
+// DataNodes, which is a JSON object in a string.
+func decodeDataNodes(attribute interface{}) (map[string]map[string]interface{}, error) {
+	s, ok := attribute.(string)
+	if !ok {
+		return nil, errNotString
+	}
+	var nodes map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// dataNodeFields are the numeric fields of the DataNodes in the LiveNodes
+// and DeadNodes attributes exported by the datanode_details sub-collector. Dead
+// DataNodes only have lastContact.
+var dataNodeFields = []struct {
+	field, name, help string
+}{
+	{"capacity", "capacity_bytes", "Raw capacity of the DataNode."},
+	{"usedSpace", "used_bytes", "Space used by HDFS blocks on the DataNode."},
+	{"remaining", "remaining_bytes", "Space left for HDFS blocks on the DataNode."},
+	{"nonDfsUsedSpace", "non_dfs_used_bytes", "Space used by other files than HDFS blocks on the DataNode."},
+	{"numBlocks", "blocks", "Number of blocks stored on the DataNode."},
+	{"xceiverCount", "xceivers", "Number of active block transfers of the DataNode."},
+	{"lastContact", "last_contact_seconds", "Time since the last heartbeat of the DataNode."},
+	{"volfails", "volume_failures", "Number of failed volumes of the DataNode."},
+}
+
+// dataNode collects the metrics of each DataNode listed by the
+// NameNodeInfo bean, labelled by host and transfer address.
+type dataNode struct {
+	max     int
+	fields  []*prometheus.Desc
+	live    *prometheus.Desc
+	admin   *prometheus.Desc
+	omitted *prometheus.Desc
+}
+
+func newDataNode(o NameNodeOptions) infoCollectFunc {
+	labels := []string{"host", "xferaddr"}
+	desc := func(name, help string, extra ...string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(nameNodeNamespace, "datanode", name),
+			help, append(labels, extra...), o.Labels,
+		)
+	}
+	d := &dataNode{
+		max:     o.MaxDataNodes,
+		live:    desc("live", "Whether the DataNode is live rather than dead."),
+		admin:   desc("admin_state", "1 for the admin state of the DataNode, e.g. in_service or decommissioned.", "state"),
+		omitted: prometheus.NewDesc(prometheus.BuildFQName(nameNodeNamespace, "datanode", "omitted"), "Number of DataNodes not exported because of the limit on their number.", nil, o.Labels),
+	}
+	for _, f := range dataNodeFields {
+		d.fields = append(d.fields, desc(f.name, f.help))
+	}
+	return d.collect
+}
+
+func (d *dataNode) collect(info *nameNodeInfo, ch chan<- prometheus.Metric) {
+	exported, omitted := 0, 0
+	for _, attribute := range []string{"LiveNodes", "DeadNodes"} {
+		nodes, err := info.dataNodes(attribute)
+		if err != nil {
+			continue
+		}
+		var names []string
+		for name := range nodes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if d.max > 0 && exported >= d.max {
+				omitted++
+				continue
+			}
+			exported++
+			d.collectNode(name, nodes[name], attribute == "LiveNodes", ch)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(d.omitted, prometheus.GaugeValue, float64(omitted))
+}
+
+func (d *dataNode) collectNode(name string, node map[string]interface{}, live bool, ch chan<- prometheus.Metric) {
+	host := name
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		host = h
+	}
+	xferaddr, _ := node["xferaddr"].(string)
+	var v float64
+	if live {
+		v = 1
+	}
+	ch <- prometheus.MustNewConstMetric(d.live, prometheus.GaugeValue, v, host, xferaddr)
+	if state, ok := node["adminState"].(string); ok {
+		state = strings.ToLower(strings.Replace(state, " ", "_", -1))
+		ch <- prometheus.MustNewConstMetric(d.admin, prometheus.GaugeValue, 1, host, xferaddr, state)
+	}
+	for i, f := range dataNodeFields {
+		// Absent fields, e.g. of dead DataNodes or older Hadoop versions,
+		// are skipped.
+		if v, ok := node[f.field].(float64); ok {
+			ch <- prometheus.MustNewConstMetric(d.fields[i], prometheus.GaugeValue, v, host, xferaddr)
+		}
+	}
+}
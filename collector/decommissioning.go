@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+	"DecomNodes" : "{\"dn3.example.com:50010\":{\"xferaddr\":\"10.0.0.4:50010\",\"underReplicatedBlocks\":120,\"decommissionOnlyReplicas\":3,\"underReplicateInOpenFiles\":0}}",
+	"EnteringMaintenanceNodes" : "{\"dn4.example.com:50010\":{\"xferaddr\":\"10.0.0.5:50010\",\"underReplicatedBlocks\":8,\"maintenanceOnlyReplicas\":0,\"underReplicateInOpenFiles\":0}}",
+*/
+
+// retiringStates are the attributes of the NameNodeInfo bean listing the
+// DataNodes being retired, and the field counting the blocks whose only
+// replicas are on such DataNodes. EnteringMaintenanceNodes is absent before
+// Hadoop 3.
+var retiringStates = []struct {
+	attribute, state, onlyReplicas string
+}{
+	{"DecomNodes", "decommissioning", "decommissionOnlyReplicas"},
+	{"EnteringMaintenanceNodes", "entering_maintenance", "maintenanceOnlyReplicas"},
+}
+
+// decommissioning collects the progress of the DataNodes being
+// decommissioned or entering maintenance, labelled by host, transfer
+// address and state. As the NameNode does not publish when decommissioning
+// started, it tracks when each DataNode was first seen and how fast its
+// under-replicated blocks go down.
+type decommissioning struct {
+	underReplicated, onlyReplicas, openFiles, elapsed, remaining *prometheus.Desc
+
+	mtx      sync.Mutex
+	progress map[string]*progress
+}
+
+func newDecommissioning(o NameNodeOptions) infoCollectFunc {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName(nameNodeNamespace, "datanode", "decommission_"+name),
+			help, []string{"host", "xferaddr", "state"}, o.Labels,
+		)
+	}
+	d := &decommissioning{
+		underReplicated: desc("under_replicated_blocks", "Number of blocks of the DataNode that are not replicated enough elsewhere yet."),
+		onlyReplicas:    desc("only_replica_blocks", "Number of blocks whose only replicas are on DataNodes being retired."),
+		openFiles:       desc("under_replicated_open_file_blocks", "Number of under-replicated blocks of the DataNode that belong to files open for writing."),
+		elapsed:         desc("elapsed_seconds", "Time since the exporter first saw the DataNode being retired."),
+		remaining:       desc("remaining_seconds", "Estimated time until the DataNode is retired, from the rate at which its under-replicated blocks went down."),
+		progress:        map[string]*progress{},
+	}
+	return func(info *nameNodeInfo, ch chan<- prometheus.Metric) {
+		d.collect(info, ch, time.Now())
+	}
+}
+
+func (d *decommissioning) collect(info *nameNodeInfo, ch chan<- prometheus.Metric, now time.Time) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	seen := map[string]bool{}
+	// failed are the states whose DataNodes could not be decoded, which
+	// are kept as they were.
+	var failed []string
+	for _, s := range retiringStates {
+		if _, ok := info.bean.Attributes[s.attribute]; !ok {
+			continue
+		}
+		nodes, err := info.dataNodes(s.attribute)
+		if err != nil {
+			failed = append(failed, s.state+"/")
+			continue
+		}
+		var names []string
+		for name := range nodes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			key := s.state + "/" + name
+			seen[key] = true
+			d.collectNode(key, name, s.state, s.onlyReplicas, nodes[name], ch, now)
+		}
+	}
+	// Forget the DataNodes that are no longer being retired.
+	for key := range d.progress {
+		if !seen[key] && !hasAnyPrefix(key, failed) {
+			delete(d.progress, key)
+		}
+	}
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *decommissioning) collectNode(key, name, state, onlyReplicas string, node map[string]interface{}, ch chan<- prometheus.Metric, now time.Time) {
+	host := name
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		host = h
+	}
+	xferaddr, _ := node["xferaddr"].(string)
+	labels := []string{host, xferaddr, state}
+	if v, ok := node[onlyReplicas].(float64); ok {
+		ch <- prometheus.MustNewConstMetric(d.onlyReplicas, prometheus.GaugeValue, v, labels...)
+	}
+	if v, ok := node["underReplicateInOpenFiles"].(float64); ok {
+		ch <- prometheus.MustNewConstMetric(d.openFiles, prometheus.GaugeValue, v, labels...)
+	}
+
+	p, ok := d.progress[key]
+	if !ok {
+		p = &progress{first: now}
+		d.progress[key] = p
+	}
+	ch <- prometheus.MustNewConstMetric(d.elapsed, prometheus.GaugeValue, now.Sub(p.first).Seconds(), labels...)
+	blocks, ok := node["underReplicatedBlocks"].(float64)
+	if !ok {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(d.underReplicated, prometheus.GaugeValue, blocks, labels...)
+	if remaining, ok := p.update(blocks, now); ok {
+		ch <- prometheus.MustNewConstMetric(d.remaining, prometheus.GaugeValue, remaining.Seconds(), labels...)
+	}
+}
+
+// progress tracks the under-replicated blocks of a DataNode being retired.
+type progress struct {
+	first time.Time
+	// peak is the largest number of under-replicated blocks seen, at
+	// peakAt. The number rises while the NameNode finds the blocks to
+	// replicate, so the rate is measured from the peak.
+	peak   float64
+	peakAt time.Time
+}
+
+// update records the number of under-replicated blocks at now and returns
+// the estimated time until none are left, if the number went down.
+func (p *progress) update(blocks float64, now time.Time) (time.Duration, bool) {
+	if p.peakAt.IsZero() || blocks >= p.peak {
+		p.peak, p.peakAt = blocks, now
+	}
+	if blocks == 0 {
+		return 0, true
+	}
+	elapsed := now.Sub(p.peakAt)
+	if blocks >= p.peak || elapsed <= 0 {
+		return 0, false
+	}
+	rate := (p.peak - blocks) / elapsed.Seconds()
+	return time.Duration(blocks / rate * float64(time.Second)), true
+}
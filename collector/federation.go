@@ -0,0 +1,365 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+	"github.com/wyukawa/hadoop_exporter/jmx"
+)
+
+// NameNodeFederationOptions configure the collector of the nameservices of
+// a federated HDFS cluster. The settings of NameNodeOptions apply to every
+// NameNode; its URL is not used.
+type NameNodeFederationOptions struct {
+	NameNodeOptions
+	// Nameservices are the URLs of the JMX JSON servlets of the NameNodes
+	// by nameservice ID and NameNode ID, e.g.
+	// {"ns1": {"nn1": "http://nn1:50070/jmx", "nn2": "http://nn2:50070/jmx"}}.
+	Nameservices map[string]map[string]string
+	// IsActive makes the collector ask the /isActive servlet of the
+	// NameNodes for their HA state, see NameNodeHAOptions.
+	IsActive bool
+}
+
+// NameNodeFederation collects the NameNodes of every nameservice like
+// NameNodeHA does, labelling their metrics with the nameservice and the
+// block pool ID read from the NameNodeInfo bean, and sums up the
+// nameservices. Every NameNode has the sub-collector "blockpool" besides
+// those of NameNodeHA. The block pool ID of a nameservice is read in the
+// background; until it is known, the nameservice only has namenode_up 0
+// with empty nn_id and block_pool_id labels.
+type NameNodeFederation struct {
+	nameservices []*nameservice
+
+	mtx      sync.Mutex
+	interval time.Duration
+
+	total, active, capacity, used, blocks, files, missing *prometheus.Desc
+}
+
+// blockPool are the figures of a nameservice read by the blockpool
+// sub-collector of its NameNodes.
+type blockPool struct {
+	id                                     string
+	used, capacity, blocks, files, missing float64
+}
+
+// blockPoolIDTimeout bounds the requests reading the block pool ID of a
+// nameservice, which are not tied to a scrape.
+const blockPoolIDTimeout = time.Minute
+
+// nameservice is a nameservice whose collector is created once its block
+// pool ID is known, and again if it changes.
+type nameservice struct {
+	id      string
+	options NameNodeHAOptions
+	// up is sent while the block pool ID is not known.
+	up *prometheus.Desc
+
+	mtx         sync.Mutex
+	ha          *NameNodeHA
+	blockPoolID string
+	// resolving reports whether the block pool ID is being read.
+	resolving bool
+}
+
+// NewNameNodeFederation returns a collector for the nameservices described
+// by o.
+func NewNameNodeFederation(o NameNodeFederationOptions) (*NameNodeFederation, error) {
+	if len(o.Nameservices) == 0 {
+		return nil, fmt.Errorf("no nameservices given")
+	}
+	f := &NameNodeFederation{}
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(nameNodeNamespace, "federation", name), help, nil, o.Labels)
+	}
+	f.total = desc("nameservices", "Number of nameservices.")
+	f.active = desc("nameservices_active", "Number of nameservices with an active NameNode.")
+	f.capacity = desc("capacity_bytes", "Raw capacity of the DataNodes, which all nameservices share.")
+	f.used = desc("block_pool_used_bytes", "Space used by the block pools of all nameservices.")
+	f.blocks = desc("blocks", "Number of allocated blocks of all nameservices.")
+	f.files = desc("files", "Number of files and directories of all nameservices.")
+	f.missing = desc("missing_blocks", "Number of blocks without a live replica of all nameservices.")
+
+	var ids []string
+	for id := range o.Nameservices {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if len(o.Nameservices[id]) == 0 {
+			return nil, fmt.Errorf("no NameNodes given for nameservice %s", id)
+		}
+		ho := NameNodeHAOptions{
+			NameNodeOptions: o.NameNodeOptions,
+			NameNodes:       o.Nameservices[id],
+			IsActive:        o.IsActive,
+		}
+		ho.Labels = prometheus.Labels{"nameservice": id}
+		for name, value := range o.Labels {
+			ho.Labels[name] = value
+		}
+		// Check the options now rather than on the first scrape.
+		if _, err := newNameNodeHA(ho, nil); err != nil {
+			return nil, err
+		}
+		// The registry rejects metrics of the same name with other label
+		// names, and Prometheus drops empty labels.
+		labels := prometheus.Labels{"nn_id": "", "block_pool_id": ""}
+		for name, value := range ho.Labels {
+			labels[name] = value
+		}
+		ns := &nameservice{
+			id:      id,
+			options: ho,
+			up: prometheus.NewDesc(
+				prometheus.BuildFQName(nameNodeNamespace, "", "up"),
+				"Whether the last scrape of the daemon succeeded.",
+				nil, labels,
+			),
+		}
+		f.nameservices = append(f.nameservices, ns)
+	}
+	return f, nil
+}
+
+// get returns the collector of the nameservice, or nil if its block pool
+// ID is not known yet, in which case it starts reading it in the
+// background. If the block pool ID changed, the collector is created
+// again.
+func (f *NameNodeFederation) get(ns *nameservice) *NameNodeHA {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+	if ns.ha != nil {
+		for _, n := range ns.ha.namenodes {
+			if id := n.getBlockPool().id; id != "" && id != ns.blockPoolID {
+				log.Infof("Block pool of nameservice %s changed from %s to %s", ns.id, ns.blockPoolID, id)
+				ns.ha.StopPolling()
+				ns.ha = nil
+				if err := f.create(ns, id); err != nil {
+					log.Errorf("Error creating the collector of nameservice %s: %s", ns.id, err)
+				}
+				break
+			}
+		}
+	}
+	if ns.ha == nil && !ns.resolving {
+		ns.resolving = true
+		go f.resolve(ns)
+	}
+	return ns.ha
+}
+
+// resolve reads the block pool ID of the nameservice and creates its
+// collector.
+func (f *NameNodeFederation) resolve(ns *nameservice) {
+	ctx, cancel := context.WithTimeout(context.Background(), blockPoolIDTimeout)
+	defer cancel()
+	id, err := ns.fetchBlockPoolID(ctx)
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+	ns.resolving = false
+	if err == nil && ns.ha == nil {
+		err = f.create(ns, id)
+	}
+	if err != nil {
+		log.Errorf("Error reading the block pool ID of nameservice %s: %s", ns.id, err)
+	}
+}
+
+// create creates the collector of the nameservice for the block pool ID
+// id, polling if the federation does. ns.mtx must be held.
+func (f *NameNodeFederation) create(ns *nameservice, id string) error {
+	o := ns.options
+	o.Labels = prometheus.Labels{"block_pool_id": id}
+	for name, value := range ns.options.Labels {
+		o.Labels[name] = value
+	}
+	ha, err := newNameNodeHA(o, addBlockPool)
+	if err != nil {
+		return err
+	}
+	f.mtx.Lock()
+	interval := f.interval
+	f.mtx.Unlock()
+	if interval > 0 {
+		ha.StartPolling(interval)
+	}
+	ns.ha, ns.blockPoolID = ha, id
+	return nil
+}
+
+// fetchBlockPoolID reads the block pool ID from the NameNodeInfo bean of
+// the first NameNode of the nameservice that answers.
+func (ns *nameservice) fetchBlockPoolID(ctx context.Context) (string, error) {
+	var ids []string
+	for id := range ns.options.NameNodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var err error
+	for _, id := range ids {
+		fetcher := &jmx.Fetcher{
+			Client:          ns.options.client(),
+			URL:             ns.options.NameNodes[id],
+			Queries:         []string{nameNodeInfoBean},
+			Want:            func(name string) bool { return name == nameNodeInfoBean },
+			MaxResponseSize: ns.options.MaxResponseSize,
+		}
+		var beans []jmx.Bean
+		beans, err = fetcher.Fetch(ctx)
+		if err == nil {
+			for _, bean := range beans {
+				if bp, ok := bean.Attributes["BlockPoolId"].(string); ok && bp != "" {
+					return bp, nil
+				}
+			}
+			err = fmt.Errorf("no BlockPoolId in %s of %s", nameNodeInfoBean, ns.options.NameNodes[id])
+		}
+	}
+	return "", err
+}
+
+func (n *haNameNode) setBlockPool(bp blockPool) {
+	n.mtx.Lock()
+	n.blockPool = bp
+	n.mtx.Unlock()
+}
+
+func (n *haNameNode) getBlockPool() blockPool {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.blockPool
+}
+
+// addBlockPool adds the blockpool sub-collector to the options of the
+// NameNode, which reads the figures of the NameNode that
+// NameNodeFederation sums up from the NameNodeInfo bean.
+func addBlockPool(n *haNameNode, o *NameNodeOptions) {
+	used := prometheus.NewDesc(
+		prometheus.BuildFQName(nameNodeNamespace, "", "block_pool_used_bytes"),
+		"Space used by the block pool of the nameservice on the DataNodes.",
+		nil, o.Labels,
+	)
+	collect := func(info *nameNodeInfo, ch chan<- prometheus.Metric) {
+		number := func(attribute string) float64 {
+			v, _ := info.bean.Attributes[attribute].(float64)
+			return v
+		}
+		bp := blockPool{
+			used:     number("BlockPoolUsedSpace"),
+			capacity: number("Total"),
+			blocks:   number("TotalBlocks"),
+			files:    number("TotalFiles"),
+			missing:  number("NumberOfMissingBlocks"),
+		}
+		bp.id, _ = info.bean.Attributes["BlockPoolId"].(string)
+		ch <- prometheus.MustNewConstMetric(used, prometheus.GaugeValue, bp.used)
+		n.setBlockPool(bp)
+	}
+	o.infoCollectors = append(append([]infoCollector(nil), o.infoCollectors...), infoCollector{"blockpool", collect})
+}
+
+// Describe implements the prometheus.Collector interface. The metrics of
+// the nameservices are not described, as their labels are only known once
+// their block pool IDs are.
+func (f *NameNodeFederation) Describe(ch chan<- *prometheus.Desc) {
+	ch <- f.total
+	ch <- f.active
+	ch <- f.capacity
+	ch <- f.used
+	ch <- f.blocks
+	ch <- f.files
+	ch <- f.missing
+}
+
+// Collect implements the prometheus.Collector interface.
+func (f *NameNodeFederation) Collect(ch chan<- prometheus.Metric) {
+	f.CollectContext(context.Background(), ch)
+}
+
+// CollectContext implements the scrape.ContextCollector interface. The
+// nameservices are collected concurrently. The sums only cover the
+// nameservices with an active NameNode, whose figures they use.
+func (f *NameNodeFederation) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	pools := make([]*blockPool, len(f.nameservices))
+	var wg sync.WaitGroup
+	for i, ns := range f.nameservices {
+		wg.Add(1)
+		go func(i int, ns *nameservice) {
+			defer wg.Done()
+			ha := f.get(ns)
+			if ha == nil {
+				ch <- prometheus.MustNewConstMetric(ns.up, prometheus.GaugeValue, 0)
+				return
+			}
+			ha.CollectContext(ctx, ch)
+			for _, n := range ha.namenodes {
+				if n.getState() == "active" {
+					bp := n.getBlockPool()
+					pools[i] = &bp
+					return
+				}
+			}
+		}(i, ns)
+	}
+	wg.Wait()
+
+	var active, capacity, used, blocks, files, missing float64
+	for _, bp := range pools {
+		if bp == nil {
+			continue
+		}
+		active++
+		// The DataNodes store the blocks of all nameservices.
+		if bp.capacity > capacity {
+			capacity = bp.capacity
+		}
+		used += bp.used
+		blocks += bp.blocks
+		files += bp.files
+		missing += bp.missing
+	}
+	ch <- prometheus.MustNewConstMetric(f.total, prometheus.GaugeValue, float64(len(f.nameservices)))
+	ch <- prometheus.MustNewConstMetric(f.active, prometheus.GaugeValue, active)
+	ch <- prometheus.MustNewConstMetric(f.capacity, prometheus.GaugeValue, capacity)
+	ch <- prometheus.MustNewConstMetric(f.used, prometheus.GaugeValue, used)
+	ch <- prometheus.MustNewConstMetric(f.blocks, prometheus.GaugeValue, blocks)
+	ch <- prometheus.MustNewConstMetric(f.files, prometheus.GaugeValue, files)
+	ch <- prometheus.MustNewConstMetric(f.missing, prometheus.GaugeValue, missing)
+}
+
+// StartPolling makes the collector poll every NameNode in the background,
+// see JMX.StartPolling. Nameservices whose block pool ID is not known yet
+// start polling once it is.
+func (f *NameNodeFederation) StartPolling(interval time.Duration) {
+	f.mtx.Lock()
+	f.interval = interval
+	f.mtx.Unlock()
+	for _, ns := range f.nameservices {
+		ns.mtx.Lock()
+		if ns.ha != nil {
+			ns.ha.StartPolling(interval)
+		}
+		ns.mtx.Unlock()
+	}
+}
+
+// StopPolling stops the polling started by StartPolling.
+func (f *NameNodeFederation) StopPolling() {
+	f.mtx.Lock()
+	f.interval = 0
+	f.mtx.Unlock()
+	for _, ns := range f.nameservices {
+		ns.mtx.Lock()
+		if ns.ha != nil {
+			ns.ha.StopPolling()
+		}
+		ns.mtx.Unlock()
+	}
+}
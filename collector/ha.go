@@ -0,0 +1,265 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+	"github.com/wyukawa/hadoop_exporter/jmx"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+const nameNodeStatusBean = "Hadoop:service=NameNode,name=NameNodeStatus"
+
+/*
+	{
+		"name" : "Hadoop:service=NameNode,name=NameNodeStatus",
+		"modelerType" : "org.apache.hadoop.hdfs.server.namenode.NameNode",
+		"State" : "active",
+		"NNRole" : "NameNode",
+		"HostAndPort" : "nn1.example.com:8020",
+		"SecurityEnabled" : false,
+		"LastHATransitionTime" : 1456089162512
+	}
+*/
+
+// NameNodeHAOptions configure the collector of the NameNodes of an HA
+// nameservice. The settings of NameNodeOptions apply to every NameNode;
+// its URL is not used.
+type NameNodeHAOptions struct {
+	NameNodeOptions
+	// NameNodes are the URLs of the JMX JSON servlets of the NameNodes by
+	// NameNode ID, e.g. {"nn1": "http://nn1:50070/jmx"}. The ID is the
+	// nn_id label of their metrics.
+	NameNodes map[string]string
+	// IsActive makes the collector ask the /isActive servlet of the
+	// NameNodes for their HA state instead of the NameNodeStatus bean.
+	IsActive bool
+}
+
+// NameNodeHA collects the metrics of the NameNodes of an HA nameservice
+// and how many of them are active. Every NameNode has the sub-collector
+// "ha" besides the NameNode sub-collectors, and the FSNamesystem metrics
+// carry the HA state as the ha_state label.
+type NameNodeHA struct {
+	namenodes []*haNameNode
+	active    *prometheus.Desc
+	total     *prometheus.Desc
+}
+
+// haNameNode is a NameNode of an HA nameservice and its last known state.
+type haNameNode struct {
+	*NameNode
+	id string
+
+	mtx       sync.Mutex
+	state     string
+	blockPool blockPool
+}
+
+func (n *haNameNode) setState(state string) {
+	n.mtx.Lock()
+	n.state = state
+	n.mtx.Unlock()
+}
+
+func (n *haNameNode) getState() string {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	return n.state
+}
+
+// NewNameNodeHA returns a collector for the NameNodes described by o.
+func NewNameNodeHA(o NameNodeHAOptions) (*NameNodeHA, error) {
+	return newNameNodeHA(o, nil)
+}
+
+// newNameNodeHA is NewNameNodeHA calling setup, if not nil, for every
+// NameNode before its collector is created, e.g. to add sub-collectors to
+// its options.
+func newNameNodeHA(o NameNodeHAOptions, setup func(n *haNameNode, o *NameNodeOptions)) (*NameNodeHA, error) {
+	if len(o.NameNodes) == 0 {
+		return nil, fmt.Errorf("no NameNodes given")
+	}
+	ha := &NameNodeHA{
+		active: prometheus.NewDesc(
+			prometheus.BuildFQName(nameNodeNamespace, "ha", "active_namenodes"),
+			"Number of NameNodes of the nameservice in the active state. Anything but 1 needs attention.",
+			nil, o.Labels,
+		),
+		total: prometheus.NewDesc(
+			prometheus.BuildFQName(nameNodeNamespace, "ha", "namenodes"),
+			"Number of NameNodes of the nameservice.",
+			nil, o.Labels,
+		),
+	}
+	var ids []string
+	for id := range o.NameNodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		no := o.NameNodeOptions
+		no.URL = o.NameNodes[id]
+		no.Labels = prometheus.Labels{"nn_id": id}
+		for name, value := range o.Labels {
+			no.Labels[name] = value
+		}
+		if !contains(no.TagLabels, "HAState") {
+			no.TagLabels = append(append([]string(nil), no.TagLabels...), "HAState")
+		}
+		n := &haNameNode{id: id}
+		if setup != nil {
+			setup(n, &no)
+		}
+		nn, err := NewNameNode(no)
+		if err != nil {
+			return nil, err
+		}
+		n.NameNode = nn
+		state := prometheus.NewDesc(
+			prometheus.BuildFQName(nameNodeNamespace, "ha", "state"),
+			"HA state of the NameNode, 1 for its current state.",
+			[]string{"state"}, no.Labels,
+		)
+		fetch := n.jmxState(no)
+		if o.IsActive {
+			fetch = n.servletState(no)
+		}
+		nn.add("ha", func(ctx context.Context, ch chan<- prometheus.Metric) error {
+			s, err := fetch(ctx)
+			if err != nil {
+				log.Errorf("Error scraping NameNode at %s for collector ha: %s", no.URL, err)
+				n.setState("")
+				return err
+			}
+			n.setState(s)
+			ch <- prometheus.MustNewConstMetric(state, prometheus.GaugeValue, 1, s)
+			return nil
+		})
+		ha.namenodes = append(ha.namenodes, n)
+	}
+	return ha, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// jmxState returns a function reading the HA state of the NameNode from
+// the NameNodeStatus bean.
+func (n *haNameNode) jmxState(o NameNodeOptions) func(ctx context.Context) (string, error) {
+	fetcher := &jmx.Fetcher{
+		Client:          o.client(),
+		URL:             o.URL,
+		Queries:         []string{nameNodeStatusBean},
+		Want:            func(name string) bool { return name == nameNodeStatusBean },
+		MaxResponseSize: o.MaxResponseSize,
+	}
+	return func(ctx context.Context) (string, error) {
+		beans, err := fetcher.Fetch(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, bean := range beans {
+			if s, ok := bean.Attributes["State"].(string); ok {
+				return s, nil
+			}
+		}
+		return "", fmt.Errorf("no State attribute in %s", nameNodeStatusBean)
+	}
+}
+
+// servletState returns a function asking the /isActive servlet of the
+// NameNode, which answers 200 if it is active and 405 if not.
+func (n *haNameNode) servletState(o NameNodeOptions) func(ctx context.Context) (string, error) {
+	u, err := url.Parse(o.URL)
+	if err == nil {
+		u.Path = "/isActive"
+		u.RawQuery = ""
+	}
+	client := o.client()
+	return func(ctx context.Context) (string, error) {
+		if err != nil {
+			return "", err
+		}
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return "", &scrape.Error{Phase: scrape.PhaseConnect, Err: err}
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return "active", nil
+		case http.StatusMethodNotAllowed:
+			return "standby", nil
+		}
+		return "", &scrape.Error{Phase: scrape.PhaseStatus, Err: fmt.Errorf("%s returned %s", u, resp.Status)}
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (ha *NameNodeHA) Describe(ch chan<- *prometheus.Desc) {
+	for _, n := range ha.namenodes {
+		n.Describe(ch)
+	}
+	ch <- ha.active
+	ch <- ha.total
+}
+
+// Collect implements the prometheus.Collector interface.
+func (ha *NameNodeHA) Collect(ch chan<- prometheus.Metric) {
+	ha.CollectContext(context.Background(), ch)
+}
+
+// CollectContext implements the scrape.ContextCollector interface. The
+// NameNodes are collected concurrently.
+func (ha *NameNodeHA) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+	for _, n := range ha.namenodes {
+		wg.Add(1)
+		go func(n *haNameNode) {
+			defer wg.Done()
+			n.CollectContext(ctx, ch)
+		}(n)
+	}
+	wg.Wait()
+	active := 0
+	for _, n := range ha.namenodes {
+		if n.getState() == "active" {
+			active++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(ha.active, prometheus.GaugeValue, float64(active))
+	ch <- prometheus.MustNewConstMetric(ha.total, prometheus.GaugeValue, float64(len(ha.namenodes)))
+}
+
+// StartPolling makes the collector poll every NameNode in the background,
+// see JMX.StartPolling.
+func (ha *NameNodeHA) StartPolling(interval time.Duration) {
+	for _, n := range ha.namenodes {
+		n.StartPolling(interval)
+	}
+}
+
+// StopPolling stops the polling started by StartPolling.
+func (ha *NameNodeHA) StopPolling() {
+	for _, n := range ha.namenodes {
+		n.StopPolling()
+	}
+}
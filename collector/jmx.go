@@ -0,0 +1,178 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+	"github.com/wyukawa/hadoop_exporter/jmx"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+// JMXOptions configure the collector of any daemon with a JMX JSON servlet.
+// URL is the address of the servlet, e.g. "http://localhost:8042/jmx". The
+// collector has no sub-collectors.
+type JMXOptions struct {
+	Options
+	// Namespace prefixes the metric names. It defaults to "hadoop".
+	Namespace string
+	// Rules map bean attributes to metrics.
+	Rules []jmx.Rule
+	// Auto, if not nil, exports the attributes that no rule matches. If
+	// neither Rules nor Auto is set, every numeric attribute is exported.
+	Auto *jmx.Auto
+	// TagLabels are the tags of the beans, e.g. "Hostname" for the
+	// attribute tag.Hostname, added as labels to their metrics, see
+	// jmx.Mapper.SetTagLabels.
+	TagLabels []string
+	// MaxResponseSize, if positive, is the largest /jmx response in bytes
+	// that is read.
+	MaxResponseSize int64
+}
+
+// JMX collects the metrics of a daemon from its JMX JSON servlet.
+type JMX struct {
+	*daemon
+	name    string
+	options JMXOptions
+}
+
+// NewJMX returns a collector for the daemon described by o. Its metrics are
+// reported as those of the collector "jmx".
+func NewJMX(o JMXOptions) (*JMX, error) {
+	if _, err := o.enabled(nil); err != nil {
+		return nil, err
+	}
+	if o.Namespace == "" {
+		o.Namespace = "hadoop"
+	}
+	if o.Rules == nil && o.Auto == nil {
+		o.Auto = &jmx.Auto{}
+	}
+	j := newJMX(o, "daemon")
+	mapper, err := j.newMapper(o.Rules, nil, o.Auto)
+	if err != nil {
+		return nil, err
+	}
+	j.addMapper("jmx", mapper)
+	return j, nil
+}
+
+// newJMX returns a collector without sub-collectors for the daemon called
+// name, e.g. "NameNode".
+func newJMX(o JMXOptions, name string) *JMX {
+	return &JMX{
+		daemon:  newDaemon(o.Namespace, o.URL, o.Labels),
+		name:    name,
+		options: o,
+	}
+}
+
+// newMapper returns a mapper applying rules, the compat rules keeping
+// legacy names (see jmx.Mapper.AddCompatRules) and auto, if not nil.
+func (j *JMX) newMapper(rules, compat []jmx.Rule, auto *jmx.Auto) (*jmx.Mapper, error) {
+	mapper, err := jmx.NewMapper(j.options.Namespace, j.options.Labels, rules)
+	if err != nil {
+		return nil, err
+	}
+	if err := mapper.AddCompatRules(compat); err != nil {
+		return nil, err
+	}
+	if auto != nil {
+		if err := mapper.EnableAuto(*auto); err != nil {
+			return nil, err
+		}
+	}
+	mapper.SetTagLabels(j.options.TagLabels)
+	return mapper, nil
+}
+
+// section is a sub-collector of a JMX collector. It fetches the beans
+// matching its queries and selected by want, see jmx.Fetcher, and turns
+// them into metrics with mapper and collect, either of which may be nil.
+type section struct {
+	queries []string
+	want    func(name string) bool
+	mapper  *jmx.Mapper
+	collect func(beans []jmx.Bean, ch chan<- prometheus.Metric)
+}
+
+// addMapper adds the sub-collector called name that applies mapper to the
+// beans it needs.
+func (j *JMX) addMapper(name string, mapper *jmx.Mapper) {
+	j.addSection(name, section{
+		queries: mapper.Queries(),
+		want:    mapper.Wants,
+		mapper:  mapper,
+	})
+}
+
+// addSection adds the sub-collector called name.
+func (j *JMX) addSection(name string, s section) {
+	j.add(name, j.sectionFunc(name, s))
+}
+
+// addSharedSection adds a sub-collector for the collectors called names,
+// which fetches the beans of s once per scrape for all of them.
+func (j *JMX) addSharedSection(names []string, s section) {
+	j.addShared(names, j.sectionFunc(strings.Join(names, ","), s))
+}
+
+// sectionFunc returns the function collecting s for the collector called
+// name.
+func (j *JMX) sectionFunc(name string, s section) scrape.CollectFunc {
+	fetcher := &jmx.Fetcher{
+		Client:          j.options.client(),
+		URL:             j.options.URL,
+		Queries:         s.queries,
+		Want:            s.want,
+		MaxResponseSize: j.options.MaxResponseSize,
+	}
+	return func(ctx context.Context, ch chan<- prometheus.Metric) error {
+		beans, err := fetcher.Fetch(ctx)
+		if err != nil {
+			log.Errorf("Error scraping %s at %s for collector %s: %s", j.name, j.url, name, err)
+			return err
+		}
+		if s.mapper != nil {
+			for _, m := range s.mapper.Collect(beans, ch) {
+				j.metrics.MissingAttribute(m.Bean, m.Attribute)
+			}
+		}
+		if s.collect != nil {
+			s.collect(beans, ch)
+		}
+		return nil
+	}
+}
+
+// Describe implements the prometheus.Collector interface. Only the scrape
+// metrics are described, the names of the others come from the beans.
+func (j *JMX) Describe(ch chan<- *prometheus.Desc) {
+	j.describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (j *JMX) Collect(ch chan<- prometheus.Metric) {
+	j.CollectContext(context.Background(), ch)
+}
+
+// CollectContext implements the scrape.ContextCollector interface.
+func (j *JMX) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	j.collectContext(ctx, ch)
+}
+
+// StartPolling makes the collector fetch from the daemon every interval in
+// the background and serve scrapes from the last successful fetch. It must
+// be called before the collector is registered.
+func (j *JMX) StartPolling(interval time.Duration) {
+	j.startPolling(interval)
+}
+
+// StopPolling stops the background polling started by StartPolling, e.g.
+// before the collector is replaced.
+func (j *JMX) StopPolling() {
+	j.stopPolling()
+}
@@ -0,0 +1,29 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/wyukawa/hadoop_exporter/jmx"
+)
+
+// TestJMXAuto exports the NameNode's beans without rules.
+func TestJMXAuto(t *testing.T) {
+	namenode := newNameNode()
+	defer namenode.Close()
+
+	j, err := NewJMX(JMXOptions{
+		Options: Options{URL: namenode.URL + "/jmx"},
+		Auto: &jmx.Auto{
+			DenyBeans:      []string{"java.lang:type=GarbageCollector,.*"},
+			DenyAttributes: []string{"Capacity.*", "HeapMemoryUsage.init"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, []string{
+		`hadoop_up 1`,
+		`hadoop_Hadoop_NameNode_BlocksTotal{name="FSNamesystem"} 67`,
+		`hadoop_java_lang_Memory_HeapMemoryUsage_used 1.24571464e+08`,
+	}, []string{"GarbageCollector", "Capacity", "HeapMemoryUsage_init", "HAState"}, j)
+}
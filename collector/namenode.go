@@ -0,0 +1,403 @@
+package collector
+
+import (
+	"github.com/wyukawa/hadoop_exporter/jmx"
+)
+
+const nameNodeNamespace = "namenode"
+
+/*
+	{
+		"name" : "Hadoop:service=NameNode,name=FSNamesystem",
+		"modelerType" : "FSNamesystem",
+		"tag.Context" : "dfs",
+		"tag.HAState" : "active",
+		"tag.TotalSyncTimes" : "23 6 ",
+		"tag.Hostname" : "CNHORTO7502.line.ism",
+		"MissingBlocks" : 0,
+		"MissingReplOneBlocks" : 0,
+		"ExpiredHeartbeats" : 0,
+		"TransactionsSinceLastCheckpoint" : 2007,
+		"TransactionsSinceLastLogRoll" : 7,
+		"LastWrittenTransactionId" : 172706,
+		"LastCheckpointTime" : 1456089173101,
+		"CapacityTotal" : 307099828224,
+		"CapacityTotalGB" : 286.0,
+		"CapacityUsed" : 1471291392,
+		"CapacityUsedGB" : 1.0,
+		"CapacityRemaining" : 279994568704,
+		"CapacityRemainingGB" : 261.0,
+		"CapacityUsedNonDFS" : 25633968128,
+		"TotalLoad" : 6,
+		"SnapshottableDirectories" : 0,
+		"Snapshots" : 0,
+		"LockQueueLength" : 0,
+		"BlocksTotal" : 67,
+		"NumFilesUnderConstruction" : 0,
+		"NumActiveClients" : 0,
+		"FilesTotal" : 184,
+		"PendingReplicationBlocks" : 0,
+		"UnderReplicatedBlocks" : 0,
+		"CorruptBlocks" : 0,
+		"ScheduledReplicationBlocks" : 0,
+		"PendingDeletionBlocks" : 0,
+		"ExcessBlocks" : 0,
+		"PostponedMisreplicatedBlocks" : 0,
+		"PendingDataNodeMessageCount" : 0,
+		"MillisSinceLastLoadedEdits" : 0,
+		"BlockCapacity" : 2097152,
+		"StaleDataNodes" : 0,
+		"TotalFiles" : 184,
+		"TotalSyncCount" : 7
+	}
+*/
+
+// fsNamesystemMetrics are the metrics exported from the FSNamesystem bean.
+var fsNamesystemMetrics = []struct {
+	attribute, name, help string
+}{
+	{"MissingBlocks", "missing_blocks", "Number of blocks without a live replica."},
+	{"CapacityTotal", "capacity_bytes", "Raw capacity of the DataNodes."},
+	{"CapacityUsed", "capacity_used_bytes", "Space used by HDFS blocks on the DataNodes."},
+	{"CapacityRemaining", "capacity_remaining_bytes", "Space left for HDFS blocks on the DataNodes."},
+	{"CapacityUsedNonDFS", "capacity_used_non_dfs_bytes", "Space used by other files than HDFS blocks on the DataNodes."},
+	{"BlocksTotal", "blocks", "Number of allocated blocks."},
+	{"FilesTotal", "files", "Number of files and directories."},
+	{"CorruptBlocks", "corrupt_blocks", "Number of blocks with corrupt replicas."},
+	{"ExcessBlocks", "excess_blocks", "Number of blocks with more replicas than required."},
+	{"StaleDataNodes", "stale_datanodes", "Number of DataNodes whose heartbeats are late."},
+}
+
+/*
+	{
+		"name" : "Hadoop:service=NameNode,name=FSNamesystemState",
+		"modelerType" : "org.apache.hadoop.hdfs.server.namenode.FSNamesystem",
+		"BlocksTotal" : 67,
+		"CapacityTotal" : 307099828224,
+		"FSState" : "Operational",
+		"NumLiveDataNodes" : 2,
+		"NumDeadDataNodes" : 1,
+		"NumDecomLiveDataNodes" : 0,
+		"NumDecomDeadDataNodes" : 0,
+		"NumDecommissioningDataNodes" : 0,
+		"NumStaleStorages" : 0,
+		"VolumeFailuresTotal" : 0,
+		"EstimatedCapacityLostTotal" : 0,
+		...
+	}
+*/
+
+// fsNamesystemStateMetrics are the metrics exported from the
+// FSNamesystemState bean.
+var fsNamesystemStateMetrics = []struct {
+	attribute, name, help string
+}{
+	{"NumLiveDataNodes", "live_datanodes", "Number of live DataNodes."},
+	{"NumDeadDataNodes", "dead_datanodes", "Number of dead DataNodes."},
+	{"NumDecomLiveDataNodes", "decommissioned_live_datanodes", "Number of decommissioned DataNodes that are still live."},
+	{"NumStaleStorages", "stale_storages", "Number of storages whose block reports are outdated."},
+	{"VolumeFailuresTotal", "volume_failures", "Number of failed volumes of all DataNodes."},
+	{"EstimatedCapacityLostTotal", "estimated_capacity_lost_bytes", "Estimated capacity lost to failed volumes."},
+}
+
+// nameNodeCollector is a sub-collector of the NameNode collector. It either
+// maps beans with rules or, if info is set, collects the NameNodeInfo bean
+// itself.
+type nameNodeCollector struct {
+	SubCollector
+	rules func() []jmx.Rule
+	// legacy export the metrics under the names of earlier versions.
+	legacy func() []jmx.Rule
+	// info returns the function collecting the NameNodeInfo bean, which
+	// the enabled sub-collectors reading it share, with the options of the
+	// NameNode collector.
+	info func(o NameNodeOptions) infoCollectFunc
+}
+
+// nameNodeCollectors are the sub-collectors of the NameNode collector.
+var nameNodeCollectors = []nameNodeCollector{
+	{SubCollector: SubCollector{"fsnamesystem", "Capacity, blocks and files of the FSNamesystem bean.", true}, rules: fsNamesystemRules, legacy: legacyFSNamesystemRules},
+	{SubCollector: SubCollector{"fsnamesystemstate", "DataNodes, volume failures and safe mode of the FSNamesystemState bean.", true}, rules: fsNamesystemStateRules},
+	{SubCollector: SubCollector{"jvm", "Garbage collections and heap of the JVM.", true}, rules: jvmRules, legacy: legacyJVMRules},
+	{SubCollector: SubCollector{"rpc", "Queues, connections and latencies of the RPC servers.", true}, rules: rpcRules},
+	{SubCollector: SubCollector{"datanodes", "Number of DataNodes by state from the NameNodeInfo bean.", true}, info: newDataNodes},
+	{SubCollector: SubCollector{"datanode_details", "Capacity, blocks and state of each DataNode from the NameNodeInfo bean.", false}, info: newDataNode},
+	{SubCollector: SubCollector{"decommissioning", "Progress of each DataNode being decommissioned or entering maintenance from the NameNodeInfo bean.", false}, info: newDecommissioning},
+}
+
+// NameNodeCollectors returns the sub-collectors of the NameNode collector.
+func NameNodeCollectors() []SubCollector {
+	var cs []SubCollector
+	for _, c := range nameNodeCollectors {
+		cs = append(cs, c.SubCollector)
+	}
+	return cs
+}
+
+// fsNamesystemRules export the FSNamesystem bean.
+func fsNamesystemRules() []jmx.Rule {
+	var rules []jmx.Rule
+	for _, m := range fsNamesystemMetrics {
+		rules = append(rules, jmx.Rule{
+			Bean:      "Hadoop:service=NameNode,name=FSNamesystem",
+			Attribute: m.attribute,
+			Name:      m.name,
+			Help:      m.help,
+		})
+	}
+	rules = append(rules, jmx.Rule{
+		Bean:      "Hadoop:service=NameNode,name=FSNamesystem",
+		Attribute: "tag.HAState",
+		Name:      "active",
+		Help:      "Whether the NameNode is the active NameNode of its HA pair.",
+		Values:    map[string]float64{"active": 1},
+	})
+	return rules
+}
+
+// legacyFSNamesystemRules export the FSNamesystem bean under the names of
+// earlier versions, e.g. namenode_CapacityTotal.
+func legacyFSNamesystemRules() []jmx.Rule {
+	var rules []jmx.Rule
+	for _, m := range fsNamesystemMetrics {
+		rules = append(rules, jmx.Rule{
+			Bean:      "Hadoop:service=NameNode,name=FSNamesystem",
+			Attribute: m.attribute,
+			Name:      m.attribute,
+		})
+	}
+	rules = append(rules, jmx.Rule{
+		Bean:      "Hadoop:service=NameNode,name=FSNamesystem",
+		Attribute: "tag.HAState",
+		Name:      "isActive",
+		Values:    map[string]float64{"active": 1},
+	})
+	return rules
+}
+
+// fsNamesystemStateRules export the FSNamesystemState bean.
+func fsNamesystemStateRules() []jmx.Rule {
+	var rules []jmx.Rule
+	for _, m := range fsNamesystemStateMetrics {
+		rules = append(rules, jmx.Rule{
+			Bean:      "Hadoop:service=NameNode,name=FSNamesystemState",
+			Attribute: m.attribute,
+			Name:      m.name,
+			Help:      m.help,
+		})
+	}
+	rules = append(rules, jmx.Rule{
+		Bean:      "Hadoop:service=NameNode,name=FSNamesystemState",
+		Attribute: "FSState",
+		Name:      "safemode",
+		Help:      "Whether the NameNode is in safe mode.",
+		Values:    map[string]float64{"safeMode": 1},
+	})
+	return rules
+}
+
+/*
+	"name" : "java.lang:type=Memory",
+	"modelerType" : "sun.management.MemoryImpl",
+	"HeapMemoryUsage" : {
+		"committed" : 1060372480,
+		"init" : 1073741824,
+		"max" : 1060372480,
+		"used" : 124571464
+	},
+*/
+
+// heapMemoryUsageFields are the fields of the HeapMemoryUsage attribute of
+// the java.lang:type=Memory bean.
+var heapMemoryUsageFields = []string{"committed", "init", "max", "used"}
+
+// jvmRules export the garbage collectors and the heap of the NameNode's JVM.
+func jvmRules() []jmx.Rule {
+	rules := []jmx.Rule{
+		{
+			Bean:      "java.lang:type=GarbageCollector,name=.*",
+			Query:     "java.lang:type=GarbageCollector,*",
+			Attribute: "CollectionCount",
+			Name:      "jvm_gc_collections_total",
+			Help:      "Number of garbage collections.",
+			Type:      "counter",
+			Labels:    map[string]string{"gc": "${name}"},
+		},
+		{
+			Bean:      "java.lang:type=GarbageCollector,name=.*",
+			Query:     "java.lang:type=GarbageCollector,*",
+			Attribute: "CollectionTime",
+			Name:      "jvm_gc_collection_seconds_total",
+			Help:      "Time spent in garbage collections.",
+			Type:      "counter",
+			Labels:    map[string]string{"gc": "${name}"},
+			Scale:     0.001,
+		},
+	}
+	for _, field := range heapMemoryUsageFields {
+		rules = append(rules, jmx.Rule{
+			Bean:      "java.lang:type=Memory",
+			Attribute: "HeapMemoryUsage." + field,
+			Name:      "jvm_memory_heap_" + field + "_bytes",
+			Help:      "The " + field + " size of the heap.",
+		})
+	}
+	return rules
+}
+
+// legacyJVMRules export the garbage collectors and the heap under the names
+// of earlier versions, e.g. namenode_ParNew_CollectionTime.
+func legacyJVMRules() []jmx.Rule {
+	rules := []jmx.Rule{
+		{
+			Bean:      "java.lang:type=GarbageCollector,name=(ParNew|ConcurrentMarkSweep)",
+			Query:     "java.lang:type=GarbageCollector,*",
+			Attribute: "CollectionCount",
+			Name:      "${name}_CollectionCount",
+			Help:      "${name} GC Count",
+		},
+		{
+			Bean:      "java.lang:type=GarbageCollector,name=(ParNew|ConcurrentMarkSweep)",
+			Query:     "java.lang:type=GarbageCollector,*",
+			Attribute: "CollectionTime",
+			Name:      "${name}_CollectionTime",
+			Help:      "${name} GC Time",
+		},
+	}
+	for field, name := range map[string]string{
+		"committed": "heapMemoryUsageCommitted",
+		"init":      "heapMemoryUsageInit",
+		"max":       "heapMemoryUsageMax",
+		"used":      "heapMemoryUsageUsed",
+	} {
+		rules = append(rules, jmx.Rule{
+			Bean:      "java.lang:type=Memory",
+			Attribute: "HeapMemoryUsage." + field,
+			Name:      name,
+		})
+	}
+	return rules
+}
+
+// rpcRules export the RPC servers of the NameNode. Their rates, such as
+// RpcQueueTimeNumOps and RpcQueueTimeAvgTime, become summaries without rules.
+func rpcRules() []jmx.Rule {
+	return []jmx.Rule{
+		{
+			Bean:      "Hadoop:service=NameNode,name=RpcActivityForPort\\d+",
+			Query:     "Hadoop:service=NameNode,name=RpcActivityForPort*",
+			Attribute: "CallQueueLength",
+			Name:      "rpc_call_queue_length",
+			Help:      "Number of calls waiting in the queue of the RPC server.",
+			Labels:    map[string]string{"name": "${name}"},
+		},
+		{
+			Bean:      "Hadoop:service=NameNode,name=RpcActivityForPort\\d+",
+			Query:     "Hadoop:service=NameNode,name=RpcActivityForPort*",
+			Attribute: "NumOpenConnections",
+			Name:      "rpc_open_connections",
+			Help:      "Number of open connections to the RPC server.",
+			Labels:    map[string]string{"name": "${name}"},
+		},
+	}
+}
+
+// NameNodeOptions configure the NameNode collector. URL is the address of
+// the JMX JSON servlet, e.g. "http://localhost:50070/jmx".
+type NameNodeOptions struct {
+	Options
+	// Rules, if not nil, replace the rules of the built-in sub-collectors,
+	// and Collectors and LegacyNames are ignored.
+	Rules []jmx.Rule
+	// Auto, if not nil, exports the attributes that no rule matches.
+	Auto *jmx.Auto
+	// TagLabels are the tags of the beans, e.g. "Hostname" or "HAState",
+	// added as labels to their metrics, see jmx.Mapper.SetTagLabels.
+	TagLabels []string
+	// MaxResponseSize, if positive, is the largest /jmx response in bytes
+	// that is read.
+	MaxResponseSize int64
+	// MaxDataNodes, if positive, is the largest number of DataNodes whose
+	// metrics the datanode_details sub-collector exports.
+	MaxDataNodes int
+
+	// infoCollectors are sub-collectors reading the NameNodeInfo bean that
+	// are added regardless of Collectors and Rules, e.g. blockpool.
+	infoCollectors []infoCollector
+}
+
+// NameNode collects the metrics of a NameNode from its JMX JSON servlet.
+type NameNode struct {
+	*JMX
+}
+
+// NewNameNode returns a collector for the NameNode described by o. Each
+// enabled sub-collector fetches its beans separately, except those reading
+// the NameNodeInfo bean, which share one fetch. With o.Rules the
+// collector has the single sub-collector "rules". Otherwise o.Auto adds the
+// sub-collector "auto", which exports the beans that no enabled built-in
+// sub-collector reads.
+func NewNameNode(o NameNodeOptions) (*NameNode, error) {
+	j := newJMX(JMXOptions{
+		Options:         o.Options,
+		Namespace:       nameNodeNamespace,
+		TagLabels:       o.TagLabels,
+		MaxResponseSize: o.MaxResponseSize,
+	}, "NameNode")
+	if o.Rules != nil {
+		mapper, err := j.newMapper(o.Rules, nil, o.Auto)
+		if err != nil {
+			return nil, err
+		}
+		j.addMapper("rules", mapper)
+		j.addInfoSection(o.infoCollectors)
+		return &NameNode{j}, nil
+	}
+
+	enabled, err := o.enabled(NameNodeCollectors())
+	if err != nil {
+		return nil, err
+	}
+	var (
+		beans []string
+		infos []infoCollector
+	)
+	for _, name := range enabled {
+		for _, c := range nameNodeCollectors {
+			if c.Name != name {
+				continue
+			}
+			if c.info != nil {
+				infos = append(infos, infoCollector{name, c.info(o)})
+				continue
+			}
+			var legacy []jmx.Rule
+			if o.LegacyNames && c.legacy != nil {
+				legacy = c.legacy()
+			}
+			rules := c.rules()
+			mapper, err := j.newMapper(rules, legacy, nil)
+			if err != nil {
+				return nil, err
+			}
+			j.addMapper(name, mapper)
+			for _, r := range rules {
+				beans = append(beans, r.Bean)
+			}
+		}
+	}
+	infos = append(infos, o.infoCollectors...)
+	if j.addInfoSection(infos) {
+		beans = append(beans, nameNodeInfoBean)
+	}
+	if o.Auto != nil {
+		auto := *o.Auto
+		auto.DenyBeans = append(append([]string(nil), auto.DenyBeans...), beans...)
+		mapper, err := j.newMapper(nil, nil, &auto)
+		if err != nil {
+			return nil, err
+		}
+		j.addMapper("auto", mapper)
+	}
+	return &NameNode{j}, nil
+}
@@ -0,0 +1,556 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wyukawa/hadoop_exporter/jmx"
+)
+
+var beans = map[string]string{
+	"Hadoop:service=NameNode,name=FSNamesystem": `{"name":"Hadoop:service=NameNode,name=FSNamesystem","tag.HAState":"active","tag.Hostname":"nn1","MissingBlocks":0,"CapacityTotal":307099828224,"CapacityUsed":1471291392,"CapacityRemaining":279994568704,"CapacityUsedNonDFS":25633968128,"BlocksTotal":67,"FilesTotal":184,"CorruptBlocks":0,"ExcessBlocks":0,"StaleDataNodes":0}`,
+	"java.lang:type=Memory":                     `{"name":"java.lang:type=Memory","HeapMemoryUsage":{"committed":1060372480,"init":1073741824,"max":1060372480,"used":124571464}}`,
+	"Hadoop:service=NameNode,name=RpcActivityForPort8020": `{"name":"Hadoop:service=NameNode,name=RpcActivityForPort8020","CallQueueLength":0,"NumOpenConnections":3,"RpcQueueTimeNumOps":100,"RpcQueueTimeAvgTime":0.5,"RpcProcessingTimeNumOps":100,"RpcProcessingTimeAvgTime":1.5,` +
+		`"RpcQueueTime60sNumOps":40,"RpcQueueTime60s50thPercentileLatency":1,"RpcQueueTime60s99thPercentileLatency":7}`,
+	"Hadoop:service=NameNode,name=FSNamesystemState": `{"name":"Hadoop:service=NameNode,name=FSNamesystemState","FSState":"safeMode","NumLiveDataNodes":2,"NumDeadDataNodes":1,"NumDecomLiveDataNodes":0,"NumStaleStorages":3,"VolumeFailuresTotal":1}`,
+	"Hadoop:service=NameNode,name=NameNodeInfo":      `{"name":"Hadoop:service=NameNode,name=NameNodeInfo","Threads":45,"LiveNodes":"{\"dn1:50010\":{\"adminState\":\"In Service\"},\"dn2:50010\":{\"adminState\":\"In Service\"}}","DeadNodes":"{\"dn3:50010\":{}}","DecomNodes":"{}"}`,
+}
+
+// gcBeans returns the garbage collector beans for the nth request. Odd
+// requests come from a JVM with a ParNew collector, even ones from a JVM
+// without.
+func gcBeans(n int64) string {
+	cms := fmt.Sprintf(`{"name":"java.lang:type=GarbageCollector,name=ConcurrentMarkSweep","CollectionCount":%d,"CollectionTime":80}`, n)
+	if n%2 == 0 {
+		return cms
+	}
+	return fmt.Sprintf(`{"name":"java.lang:type=GarbageCollector,name=ParNew","CollectionCount":%d,"CollectionTime":340},`, n) + cms
+}
+
+// newNameNode serves beans on /jmx, honouring the qry patterns used by the
+// default rules.
+func newNameNode() *httptest.Server {
+	return newNameNodeWith(beans)
+}
+
+// beansWith returns the test beans with those in changes replaced or added.
+func beansWith(changes map[string]string) map[string]string {
+	b := map[string]string{}
+	for name, bean := range beans {
+		b[name] = bean
+	}
+	for name, bean := range changes {
+		b[name] = bean
+	}
+	return b
+}
+
+// newNameNodeWith is newNameNode serving the given beans.
+func newNameNodeWith(beans map[string]string) *httptest.Server {
+	var requests int64
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&requests, 1)
+		var selected []string
+		switch qry := r.URL.Query().Get("qry"); qry {
+		case "":
+			for _, b := range beans {
+				selected = append(selected, b)
+			}
+			selected = append(selected, gcBeans(n))
+		case "java.lang:type=GarbageCollector,*":
+			selected = append(selected, gcBeans(n))
+		default:
+			for name, b := range beans {
+				if ok, _ := path.Match(qry, name); ok {
+					selected = append(selected, b)
+				}
+			}
+		}
+		fmt.Fprintf(w, `{"beans":[%s]}`, strings.Join(selected, ","))
+	}))
+}
+
+var (
+	parNewCount = regexp.MustCompile(`(?m)^namenode_jvm_gc_collections_total{gc="ParNew"} (\d+)$`)
+	cmsCount    = regexp.MustCompile(`(?m)^namenode_jvm_gc_collections_total{gc="ConcurrentMarkSweep"} (\d+)$`)
+)
+
+// TestNameNodeConcurrentScrapes hammers /metrics in parallel while the NameNode
+// alternates between two JVMs, one of which has no ParNew collector. Run it
+// with -race.
+func TestNameNodeConcurrentScrapes(t *testing.T) {
+	namenode := newNameNode()
+	defer namenode.Close()
+
+	nn, err := NewNameNode(NameNodeOptions{Options: Options{URL: namenode.URL + "/jmx"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newMetricsServer(nn)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				body, err := get(server.URL)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !strings.Contains(body, "namenode_up 1") || !strings.Contains(body, "namenode_active 1") {
+					t.Errorf("scrape failed:\n%s", body)
+					return
+				}
+				cms := cmsCount.FindStringSubmatch(body)
+				if cms == nil {
+					t.Errorf("no ConcurrentMarkSweep metrics:\n%s", body)
+					return
+				}
+				parNew := parNewCount.FindStringSubmatch(body)
+				var n int64
+				fmt.Sscan(cms[1], &n)
+				if n%2 == 0 && parNew != nil {
+					t.Errorf("stale ParNew metrics served:\n%s", body)
+					return
+				}
+				if n%2 == 1 && (parNew == nil || parNew[1] != cms[1]) {
+					t.Errorf("scrape mixes two responses:\n%s", body)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestNameNodeSummaries turns the rates and quantiles of the RPC server into
+// summaries.
+func TestNameNodeSummaries(t *testing.T) {
+	namenode := newNameNode()
+	defer namenode.Close()
+
+	nn, err := NewNameNode(NameNodeOptions{Options: Options{
+		URL:        namenode.URL + "/jmx",
+		Collectors: []string{"rpc"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, []string{
+		`# TYPE namenode_rpc_queue_time_seconds summary`,
+		`namenode_rpc_queue_time_seconds_sum{name="RpcActivityForPort8020"} 0.05`,
+		`namenode_rpc_queue_time_seconds_count{name="RpcActivityForPort8020"} 100`,
+		`namenode_rpc_processing_time_seconds_sum{name="RpcActivityForPort8020"} 0.15`,
+		`namenode_rpc_queue_time_latency_seconds{name="RpcActivityForPort8020",window="60s",quantile="0.5"} 0.001`,
+		`namenode_rpc_queue_time_latency_seconds{name="RpcActivityForPort8020",window="60s",quantile="0.99"} 0.007`,
+		`namenode_rpc_queue_time_latency_seconds_count{name="RpcActivityForPort8020",window="60s"} 40`,
+		`namenode_rpc_open_connections{name="RpcActivityForPort8020"} 3`,
+	}, []string{
+		// Rate attributes are not exported as gauges.
+		"NumOps", "AvgTime",
+	}, nn)
+}
+
+// TestNameNodeLegacyNames exports the metrics under both their current
+// names and those of earlier versions.
+func TestNameNodeLegacyNames(t *testing.T) {
+	namenode := newNameNode()
+	defer namenode.Close()
+
+	nn, err := NewNameNode(NameNodeOptions{Options: Options{
+		URL:         namenode.URL + "/jmx",
+		LegacyNames: true,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, []string{
+		`namenode_capacity_bytes 3.07099828224e+11`,
+		`namenode_CapacityTotal 3.07099828224e+11`,
+		`namenode_active 1`,
+		`namenode_isActive 1`,
+		`namenode_jvm_gc_collection_seconds_total{gc="ConcurrentMarkSweep"} 0.08`,
+		`namenode_ConcurrentMarkSweep_CollectionTime 80`,
+		`namenode_jvm_memory_heap_used_bytes 1.24571464e+08`,
+		`namenode_heapMemoryUsageUsed 1.24571464e+08`,
+	}, nil, nn)
+}
+
+// TestNameNodeTagLabels labels the metrics of the FSNamesystem bean with its
+// tags.
+func TestNameNodeTagLabels(t *testing.T) {
+	namenode := newNameNode()
+	defer namenode.Close()
+
+	nn, err := NewNameNode(NameNodeOptions{
+		Options:   Options{URL: namenode.URL + "/jmx"},
+		TagLabels: []string{"Hostname", "HAState"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, []string{
+		`namenode_capacity_bytes{ha_state="active",hostname="nn1"} 3.07099828224e+11`,
+		`namenode_jvm_memory_heap_used_bytes 1.24571464e+08`,
+	}, nil, nn)
+}
+
+// TestNameNodeMissingAttributes counts the attributes that the rules expect
+// but that are absent or not numbers.
+func TestNameNodeMissingAttributes(t *testing.T) {
+	fsNamesystem := strings.Replace(beans["Hadoop:service=NameNode,name=FSNamesystem"], `"MissingBlocks":0,`, "", 1)
+	fsNamesystem = strings.Replace(fsNamesystem, `"CapacityTotal":307099828224`, `"CapacityTotal":"unknown"`, 1)
+	namenode := newNameNodeWith(beansWith(map[string]string{"Hadoop:service=NameNode,name=FSNamesystem": fsNamesystem}))
+	defer namenode.Close()
+
+	nn, err := NewNameNode(NameNodeOptions{Options: Options{
+		URL:        namenode.URL + "/jmx",
+		Collectors: []string{"fsnamesystem"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, []string{
+		`namenode_exporter_missing_attributes_total{attribute="MissingBlocks",bean="Hadoop:service=NameNode,name=FSNamesystem"} 1`,
+		`namenode_exporter_missing_attributes_total{attribute="CapacityTotal",bean="Hadoop:service=NameNode,name=FSNamesystem"} 1`,
+		"namenode_blocks 67",
+	}, []string{
+		`attribute="BlocksTotal"`,
+		"namenode_capacity_bytes ",
+	}, nn)
+}
+
+// TestNameNodeCollectors runs the default sub-collectors and the automatic
+// export, which leaves out the beans they read.
+func TestNameNodeCollectors(t *testing.T) {
+	namenode := newNameNode()
+	defer namenode.Close()
+
+	nn, err := NewNameNode(NameNodeOptions{
+		Options: Options{URL: namenode.URL + "/jmx"},
+		Auto:    &jmx.Auto{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, []string{
+		`namenode_scrape_collector_success{collector="auto"} 1`,
+		`namenode_scrape_collector_success{collector="datanodes"} 1`,
+		`namenode_scrape_collector_success{collector="fsnamesystem"} 1`,
+		`namenode_scrape_collector_success{collector="fsnamesystemstate"} 1`,
+		`namenode_scrape_collector_success{collector="jvm"} 1`,
+		`namenode_scrape_collector_success{collector="rpc"} 1`,
+		`namenode_datanodes{state="live"} 2`,
+		`namenode_datanodes{state="dead"} 1`,
+		`namenode_datanodes{state="decommissioning"} 0`,
+		`namenode_capacity_bytes 3.07099828224e+11`,
+		`namenode_live_datanodes 2`,
+		`namenode_dead_datanodes 1`,
+		`namenode_decommissioned_live_datanodes 0`,
+		`namenode_stale_storages 3`,
+		`namenode_volume_failures 1`,
+		`namenode_safemode 1`,
+	}, []string{
+		// The attributes of the fsnamesystem collector are not exported
+		// automatically.
+		"CapacityTotal",
+	}, nn)
+
+	nn, err = NewNameNode(NameNodeOptions{Options: Options{
+		URL:        namenode.URL + "/jmx",
+		Collectors: []string{},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, nil, []string{"collector_success", "namenode_datanodes"}, nn)
+}
+
+// TestDataNode exports the DataNodes listed by the NameNodeInfo bean, up to
+// the limit.
+func TestDataNode(t *testing.T) {
+	namenode := newNameNodeWith(beansWith(map[string]string{
+		nameNodeInfoBean: `{"name":"Hadoop:service=NameNode,name=NameNodeInfo",` +
+			`"LiveNodes":"{\"dn1:50010\":{\"xferaddr\":\"10.0.0.1:50010\",\"adminState\":\"In Service\",\"capacity\":1000,\"usedSpace\":100,\"remaining\":800,\"nonDfsUsedSpace\":100,\"numBlocks\":7,\"xceiverCount\":3,\"lastContact\":1,\"volfails\":0},` +
+			`\"dn2:50010\":{\"xferaddr\":\"10.0.0.2:50010\",\"adminState\":\"Decommission In Progress\",\"capacity\":2000}}",` +
+			`"DeadNodes":"{\"dn3:50010\":{\"xferaddr\":\"10.0.0.3:50010\",\"lastContact\":600,\"adminState\":\"Decommissioned\"}}","DecomNodes":"{}"}`,
+	}))
+	defer namenode.Close()
+
+	for _, tc := range []struct {
+		max          int
+		want, absent []string
+	}{
+		{0, []string{
+			`namenode_datanode_capacity_bytes{host="dn1",xferaddr="10.0.0.1:50010"} 1000`,
+			`namenode_datanode_used_bytes{host="dn1",xferaddr="10.0.0.1:50010"} 100`,
+			`namenode_datanode_remaining_bytes{host="dn1",xferaddr="10.0.0.1:50010"} 800`,
+			`namenode_datanode_non_dfs_used_bytes{host="dn1",xferaddr="10.0.0.1:50010"} 100`,
+			`namenode_datanode_blocks{host="dn1",xferaddr="10.0.0.1:50010"} 7`,
+			`namenode_datanode_xceivers{host="dn1",xferaddr="10.0.0.1:50010"} 3`,
+			`namenode_datanode_volume_failures{host="dn1",xferaddr="10.0.0.1:50010"} 0`,
+			`namenode_datanode_admin_state{host="dn2",state="decommission_in_progress",xferaddr="10.0.0.2:50010"} 1`,
+			`namenode_datanode_live{host="dn3",xferaddr="10.0.0.3:50010"} 0`,
+			`namenode_datanode_last_contact_seconds{host="dn3",xferaddr="10.0.0.3:50010"} 600`,
+			`namenode_datanode_omitted 0`,
+		}, []string{
+			`namenode_datanode_capacity_bytes{host="dn3"`,
+		}},
+		{2, []string{
+			`namenode_datanode_live{host="dn2",xferaddr="10.0.0.2:50010"} 1`,
+			`namenode_datanode_omitted 1`,
+		}, []string{
+			`host="dn3"`,
+		}},
+	} {
+		nn, err := NewNameNode(NameNodeOptions{
+			Options:      Options{URL: namenode.URL + "/jmx", Collectors: []string{"datanode_details"}},
+			MaxDataNodes: tc.max,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectMetrics(t, tc.want, tc.absent, nn)
+	}
+}
+
+// TestNameNodeInfoShared fetches the NameNodeInfo bean once per scrape for
+// all the sub-collectors reading it, and reports each of them.
+func TestNameNodeInfoShared(t *testing.T) {
+	namenode := newNameNode()
+	defer namenode.Close()
+	var fetches int64
+	counter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("qry") == nameNodeInfoBean {
+			atomic.AddInt64(&fetches, 1)
+		}
+		namenode.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer counter.Close()
+
+	nn, err := NewNameNode(NameNodeOptions{
+		Options: Options{URL: counter.URL + "/jmx", Collectors: []string{"fsnamesystem", "datanodes", "datanode_details", "decommissioning"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, []string{
+		`namenode_scrape_collector_success{collector="datanodes"} 1`,
+		`namenode_scrape_collector_success{collector="datanode_details"} 1`,
+		`namenode_scrape_collector_success{collector="decommissioning"} 1`,
+		`namenode_datanodes{state="live"} 2`,
+		`namenode_datanode_live{host="dn3",xferaddr=""} 0`,
+	}, nil, nn)
+	if n := atomic.LoadInt64(&fetches); n != 1 {
+		t.Errorf("NameNodeInfo fetched %d times, want 1", n)
+	}
+}
+
+// TestDecommissioning exports the DataNodes being decommissioned or entering
+// maintenance.
+func TestDecommissioning(t *testing.T) {
+	namenode := newNameNodeWith(beansWith(map[string]string{
+		nameNodeInfoBean: `{"name":"Hadoop:service=NameNode,name=NameNodeInfo","LiveNodes":"{}","DeadNodes":"{}",` +
+			`"DecomNodes":"{\"dn3:50010\":{\"xferaddr\":\"10.0.0.3:50010\",\"underReplicatedBlocks\":120,\"decommissionOnlyReplicas\":3,\"underReplicateInOpenFiles\":1}}",` +
+			`"EnteringMaintenanceNodes":"{\"dn4:50010\":{\"xferaddr\":\"10.0.0.4:50010\",\"underReplicatedBlocks\":0,\"maintenanceOnlyReplicas\":0,\"underReplicateInOpenFiles\":0}}"}`,
+	}))
+	defer namenode.Close()
+
+	nn, err := NewNameNode(NameNodeOptions{
+		Options: Options{URL: namenode.URL + "/jmx", Collectors: []string{"decommissioning"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, []string{
+		`namenode_datanode_decommission_under_replicated_blocks{host="dn3",state="decommissioning",xferaddr="10.0.0.3:50010"} 120`,
+		`namenode_datanode_decommission_only_replica_blocks{host="dn3",state="decommissioning",xferaddr="10.0.0.3:50010"} 3`,
+		`namenode_datanode_decommission_under_replicated_open_file_blocks{host="dn3",state="decommissioning",xferaddr="10.0.0.3:50010"} 1`,
+		`namenode_datanode_decommission_elapsed_seconds{host="dn3",state="decommissioning",xferaddr="10.0.0.3:50010"}`,
+		`namenode_datanode_decommission_remaining_seconds{host="dn4",state="entering_maintenance",xferaddr="10.0.0.4:50010"} 0`,
+	}, []string{
+		// No remaining time is estimated from a single scrape.
+		`namenode_datanode_decommission_remaining_seconds{host="dn3"`,
+	}, nn)
+}
+
+// TestDecommissioningDecodeError keeps tracking a DataNode being
+// decommissioned while its state cannot be decoded.
+func TestDecommissioningDecodeError(t *testing.T) {
+	namenode := newNameNodeWith(beansWith(map[string]string{
+		nameNodeInfoBean: `{"name":"Hadoop:service=NameNode,name=NameNodeInfo","LiveNodes":"{}","DeadNodes":"{}",` +
+			`"DecomNodes":"{\"dn3:50010\":{\"xferaddr\":\"10.0.0.3:50010\",\"underReplicatedBlocks\":120}}"}`,
+	}))
+	defer namenode.Close()
+	var broken int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&broken) == 1 {
+			fmt.Fprint(w, `{"beans":[{"name":"Hadoop:service=NameNode,name=NameNodeInfo","DecomNodes":"{"}]}`)
+			return
+		}
+		namenode.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	nn, err := NewNameNode(NameNodeOptions{
+		Options: Options{URL: server.URL + "/jmx", Collectors: []string{"decommissioning"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := `namenode_datanode_decommission_elapsed_seconds{host="dn3",state="decommissioning",xferaddr="10.0.0.3:50010"}`
+	expectMetrics(t, []string{elapsed + " 0\n"}, nil, nn)
+	atomic.StoreInt32(&broken, 1)
+	expectMetrics(t, nil, []string{elapsed}, nn)
+	atomic.StoreInt32(&broken, 0)
+	time.Sleep(10 * time.Millisecond)
+	expectMetrics(t, []string{elapsed}, []string{elapsed + " 0\n"}, nn)
+}
+
+// TestProgress estimates the time left from the under-replicated blocks
+// seen at successive scrapes, measuring the rate from their peak.
+func TestProgress(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	p := &progress{first: start}
+	for _, tc := range []struct {
+		after     time.Duration
+		blocks    float64
+		remaining time.Duration
+		ok        bool
+	}{
+		{0, 100, 0, false},
+		// Rising while the NameNode finds the blocks to replicate.
+		{time.Minute, 200, 0, false},
+		{2 * time.Minute, 150, 3 * time.Minute, true},
+		{3 * time.Minute, 150, 6 * time.Minute, true},
+		{5 * time.Minute, 0, 0, true},
+	} {
+		remaining, ok := p.update(tc.blocks, start.Add(tc.after))
+		if remaining != tc.remaining || ok != tc.ok {
+			t.Errorf("%s: got %s %t, want %s %t", tc.after, remaining, ok, tc.remaining, tc.ok)
+		}
+	}
+}
+
+// TestNameNodeHA collects an active and a standby NameNode, reading their
+// states from the NameNodeStatus bean and the /isActive servlet.
+func TestNameNodeHA(t *testing.T) {
+	var servers []*httptest.Server
+	for _, state := range []string{"active", "standby"} {
+		b := map[string]string{}
+		for name, bean := range beans {
+			b[name] = strings.Replace(bean, `"tag.HAState":"active"`, `"tag.HAState":"`+state+`"`, 1)
+		}
+		b[nameNodeStatusBean] = `{"name":"Hadoop:service=NameNode,name=NameNodeStatus","State":"` + state + `"}`
+		server := newNameNodeWith(b)
+		defer server.Close()
+		servers = append(servers, server)
+	}
+	isActive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/isActive" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "I am not Active!", http.StatusMethodNotAllowed)
+	}))
+	defer isActive.Close()
+
+	for _, tc := range []struct {
+		isActive bool
+		urls     map[string]string
+		want     []string
+	}{
+		{false, map[string]string{"nn1": servers[0].URL + "/jmx", "nn2": servers[1].URL + "/jmx"}, []string{
+			`namenode_ha_active_namenodes{cluster="a"} 1`,
+			`namenode_ha_namenodes{cluster="a"} 2`,
+			`namenode_ha_state{cluster="a",nn_id="nn1",state="active"} 1`,
+			`namenode_ha_state{cluster="a",nn_id="nn2",state="standby"} 1`,
+			`namenode_capacity_bytes{cluster="a",ha_state="standby",nn_id="nn2"} 3.07099828224e+11`,
+			`namenode_jvm_memory_heap_used_bytes{cluster="a",nn_id="nn1"} 1.24571464e+08`,
+		}},
+		{true, map[string]string{"nn1": isActive.URL + "/jmx", "nn2": "http://127.0.0.1:1/jmx"}, []string{
+			`namenode_ha_active_namenodes{cluster="a"} 0`,
+			`namenode_ha_state{cluster="a",nn_id="nn1",state="standby"} 1`,
+			`namenode_scrape_collector_success{cluster="a",collector="ha",nn_id="nn2"} 0`,
+			`namenode_up{cluster="a",nn_id="nn2"} 0`,
+		}},
+	} {
+		ha, err := NewNameNodeHA(NameNodeHAOptions{
+			NameNodeOptions: NameNodeOptions{Options: Options{
+				Labels: prometheus.Labels{"cluster": "a"},
+			}},
+			NameNodes: tc.urls,
+			IsActive:  tc.isActive,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectMetrics(t, tc.want, nil, ha)
+	}
+}
+
+// TestNameNodeFederation collects three nameservices, one of which does not
+// answer, and sums up the block pools of the others once their IDs are read.
+func TestNameNodeFederation(t *testing.T) {
+	urls := map[string]map[string]string{}
+	for i, ns := range []string{"ns1", "ns2"} {
+		server := newNameNodeWith(beansWith(map[string]string{
+			nameNodeInfoBean:   strings.Replace(beans[nameNodeInfoBean], `"Threads":45`, fmt.Sprintf(`"Threads":45,"BlockPoolId":"BP-%d","BlockPoolUsedSpace":%d,"Total":307099828224,"TotalBlocks":67,"TotalFiles":184,"NumberOfMissingBlocks":0`, i+1, (i+1)*1000), 1),
+			nameNodeStatusBean: `{"name":"Hadoop:service=NameNode,name=NameNodeStatus","State":"active"}`,
+		}))
+		defer server.Close()
+		urls[ns] = map[string]string{"nn1": server.URL + "/jmx"}
+	}
+	urls["ns3"] = map[string]string{"nn1": "http://127.0.0.1:1/jmx"}
+
+	f, err := NewNameNodeFederation(NameNodeFederationOptions{
+		NameNodeOptions: NameNodeOptions{Options: Options{
+			Labels: prometheus.Labels{"cluster": "a"},
+		}},
+		Nameservices: urls,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The block pool IDs are read in the background.
+	expectMetrics(t, []string{
+		`namenode_up{block_pool_id="",cluster="a",nameservice="ns1",nn_id=""} 0`,
+		`namenode_federation_nameservices_active{cluster="a"} 0`,
+	}, nil, f)
+	server := newMetricsServer(f)
+	defer server.Close()
+	waitFor(t, "the block pool IDs", func() bool {
+		body, err := get(server.URL)
+		return err == nil && strings.Contains(body, `namenode_federation_nameservices_active{cluster="a"} 2`)
+	})
+	expectMetrics(t, []string{
+		`namenode_federation_nameservices{cluster="a"} 3`,
+		`namenode_federation_nameservices_active{cluster="a"} 2`,
+		`namenode_federation_capacity_bytes{cluster="a"} 3.07099828224e+11`,
+		`namenode_federation_block_pool_used_bytes{cluster="a"} 3000`,
+		`namenode_federation_blocks{cluster="a"} 134`,
+		`namenode_federation_files{cluster="a"} 368`,
+		`namenode_block_pool_used_bytes{block_pool_id="BP-2",cluster="a",nameservice="ns2",nn_id="nn1"} 2000`,
+		`namenode_capacity_bytes{block_pool_id="BP-1",cluster="a",ha_state="active",nameservice="ns1",nn_id="nn1"} 3.07099828224e+11`,
+		`namenode_jvm_memory_heap_used_bytes{block_pool_id="BP-2",cluster="a",nameservice="ns2",nn_id="nn1"} 1.24571464e+08`,
+		`namenode_ha_active_namenodes{block_pool_id="BP-1",cluster="a",nameservice="ns1"} 1`,
+		// ns3 has no block pool ID.
+		`namenode_up{block_pool_id="",cluster="a",nameservice="ns3",nn_id=""} 0`,
+	}, []string{
+		`nameservice="ns1",nn_id=""`,
+		`nameservice="ns3",nn_id="nn1"`,
+		`namenode_ha_active_namenodes{block_pool_id=""`,
+	}, f)
+}
@@ -0,0 +1,217 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+const resourceManagerNamespace = "resourcemanager"
+
+/*
+  "clusterMetrics": {
+    "activeNodes": 3,
+    "rebootedNodes": 0,
+    "decommissionedNodes": 0,
+    "unhealthyNodes": 0,
+    "lostNodes": 0,
+    "totalNodes": 3,
+    "totalVirtualCores": 9,
+    "availableMB": 6144,
+    "reservedMB": 0,
+    "appsKilled": 0,
+    "appsFailed": 1,
+    "appsRunning": 0,
+    "appsPending": 0,
+    "appsCompleted": 9,
+    "appsSubmitted": 10,
+    "allocatedMB": 0,
+    "reservedVirtualCores": 0,
+    "availableVirtualCores": 9,
+    "allocatedVirtualCores": 0,
+    "containersAllocated": 0,
+    "containersReserved": 0,
+    "containersPending": 0,
+    "totalMB": 6144
+  }
+*/
+
+// mb converts megabytes to bytes.
+const mb = 1 << 20
+
+// restField is a numeric field of a REST API response and the metric it is
+// exported as. Fields of nested objects are named by their path, e.g.
+// "resourcesUsed.memory".
+type restField struct {
+	field, name, help string
+	valueType         prometheus.ValueType
+	scale             float64
+}
+
+// clusterMetricsFields are the fields of /ws/v1/cluster/metrics and the
+// metrics they are exported as.
+var clusterMetricsFields = []restField{
+	{"appsSubmitted", "apps_submitted_total", "Number of submitted applications.", prometheus.CounterValue, 1},
+	{"appsCompleted", "apps_completed_total", "Number of completed applications.", prometheus.CounterValue, 1},
+	{"appsFailed", "apps_failed_total", "Number of failed applications.", prometheus.CounterValue, 1},
+	{"appsKilled", "apps_killed_total", "Number of killed applications.", prometheus.CounterValue, 1},
+	{"appsPending", "apps_pending", "Number of pending applications.", prometheus.GaugeValue, 1},
+	{"appsRunning", "apps_running", "Number of running applications.", prometheus.GaugeValue, 1},
+	{"totalMB", "memory_capacity_bytes", "Memory of the cluster.", prometheus.GaugeValue, mb},
+	{"availableMB", "memory_available_bytes", "Memory available for containers.", prometheus.GaugeValue, mb},
+	{"allocatedMB", "memory_allocated_bytes", "Memory allocated to containers.", prometheus.GaugeValue, mb},
+	{"reservedMB", "memory_reserved_bytes", "Memory reserved for containers.", prometheus.GaugeValue, mb},
+	{"totalVirtualCores", "vcores_capacity", "Virtual cores of the cluster.", prometheus.GaugeValue, 1},
+	{"availableVirtualCores", "vcores_available", "Virtual cores available for containers.", prometheus.GaugeValue, 1},
+	{"allocatedVirtualCores", "vcores_allocated", "Virtual cores allocated to containers.", prometheus.GaugeValue, 1},
+	{"reservedVirtualCores", "vcores_reserved", "Virtual cores reserved for containers.", prometheus.GaugeValue, 1},
+	{"containersAllocated", "containers_allocated", "Number of allocated containers.", prometheus.GaugeValue, 1},
+	{"containersReserved", "containers_reserved", "Number of reserved containers.", prometheus.GaugeValue, 1},
+	{"containersPending", "containers_pending", "Number of pending containers.", prometheus.GaugeValue, 1},
+	{"totalNodes", "nodes", "Number of NodeManagers in service.", prometheus.GaugeValue, 1},
+	{"activeNodes", "nodes_active", "Number of active NodeManagers.", prometheus.GaugeValue, 1},
+	{"lostNodes", "nodes_lost", "Number of NodeManagers that stopped sending heartbeats.", prometheus.GaugeValue, 1},
+	{"unhealthyNodes", "nodes_unhealthy", "Number of unhealthy NodeManagers.", prometheus.GaugeValue, 1},
+	{"decommissionedNodes", "nodes_decommissioned", "Number of decommissioned NodeManagers.", prometheus.GaugeValue, 1},
+	{"rebootedNodes", "nodes_rebooted", "Number of rebooted NodeManagers.", prometheus.GaugeValue, 1},
+}
+
+// resourceManagerCollectors are the sub-collectors of the ResourceManager
+// collector.
+var resourceManagerCollectors = []SubCollector{
+	{"cluster_metrics", "Applications, resources and NodeManagers of the cluster.", true},
+	{"scheduler", "Capacity and usage of the CapacityScheduler queues.", true},
+	{"nodes", "State and resources of each NodeManager.", false},
+	{"apps", "Resources and progress of each running application.", false},
+}
+
+// ResourceManagerCollectors returns the sub-collectors of the
+// ResourceManager collector.
+func ResourceManagerCollectors() []SubCollector {
+	return append([]SubCollector(nil), resourceManagerCollectors...)
+}
+
+// ResourceManager collects the metrics of a ResourceManager from its REST
+// API.
+type ResourceManager struct {
+	*daemon
+	client *http.Client
+	labels prometheus.Labels
+	descs  []*prometheus.Desc
+	// clusterMetrics and legacyClusterMetrics hold the descriptors of the
+	// metrics made of clusterMetricsFields, in the same order.
+	clusterMetrics       []*prometheus.Desc
+	legacyClusterMetrics []*prometheus.Desc
+}
+
+// NewResourceManager returns a collector for the ResourceManager described
+// by o. URL is the address of the ResourceManager's web interface, e.g.
+// "http://localhost:8088". Each enabled sub-collector requests its resource
+// of the REST API separately.
+func NewResourceManager(o Options) (*ResourceManager, error) {
+	enabled, err := o.enabled(ResourceManagerCollectors())
+	if err != nil {
+		return nil, err
+	}
+	r := &ResourceManager{
+		daemon: newDaemon(resourceManagerNamespace, o.URL, o.Labels),
+		client: o.client(),
+		labels: o.Labels,
+	}
+	for _, name := range enabled {
+		switch name {
+		case "cluster_metrics":
+			for _, f := range clusterMetricsFields {
+				r.clusterMetrics = append(r.clusterMetrics, r.newDesc(f.name, f.help, nil))
+				if o.LegacyNames {
+					r.legacyClusterMetrics = append(r.legacyClusterMetrics, r.newDesc(f.field, f.field, nil))
+				}
+			}
+			r.add(name, r.collectClusterMetrics)
+		case "scheduler":
+			r.add(name, r.newSchedulerCollector())
+		case "nodes":
+			r.add(name, r.newNodesCollector())
+		case "apps":
+			r.add(name, r.newAppsCollector())
+		}
+	}
+	return r, nil
+}
+
+// newDesc returns the descriptor of the metric called name, which is
+// described by the collector.
+func (r *ResourceManager) newDesc(name, help string, labels []string) *prometheus.Desc {
+	desc := prometheus.NewDesc(prometheus.BuildFQName(resourceManagerNamespace, "", name), help, labels, r.labels)
+	r.descs = append(r.descs, desc)
+	return desc
+}
+
+// Describe implements the prometheus.Collector interface.
+func (r *ResourceManager) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range r.descs {
+		ch <- desc
+	}
+	r.describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (r *ResourceManager) Collect(ch chan<- prometheus.Metric) {
+	r.CollectContext(context.Background(), ch)
+}
+
+// CollectContext implements the scrape.ContextCollector interface.
+func (r *ResourceManager) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	r.collectContext(ctx, ch)
+}
+
+// StartPolling makes the collector fetch from the ResourceManager every
+// interval in the background and serve scrapes from the last successful
+// fetch. It must be called before the collector is registered.
+func (r *ResourceManager) StartPolling(interval time.Duration) {
+	r.startPolling(interval)
+}
+
+// StopPolling stops the background polling started by StartPolling, e.g.
+// before the collector is replaced.
+func (r *ResourceManager) StopPolling() {
+	r.stopPolling()
+}
+
+// get fetches path of the REST API for the sub-collector called collector
+// and decodes it into v.
+func (r *ResourceManager) get(ctx context.Context, collector, path string, v interface{}) error {
+	if err := scrape.JSON(ctx, r.client, r.url+path, v); err != nil {
+		log.Errorf("Error scraping ResourceManager at %s for collector %s: %s", r.url, collector, err)
+		return err
+	}
+	return nil
+}
+
+// collectClusterMetrics fetches /ws/v1/cluster/metrics and sends the cluster
+// metrics if that succeeded. Fields that are absent or not numbers are
+// skipped.
+func (r *ResourceManager) collectClusterMetrics(ctx context.Context, ch chan<- prometheus.Metric) error {
+	var f struct {
+		ClusterMetrics map[string]interface{} `json:"clusterMetrics"`
+	}
+	if err := r.get(ctx, "cluster_metrics", "/ws/v1/cluster/metrics", &f); err != nil {
+		return err
+	}
+	for i, field := range clusterMetricsFields {
+		v, ok := f.ClusterMetrics[field.field].(float64)
+		if !ok {
+			r.metrics.MissingAttribute("clusterMetrics", field.field)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(r.clusterMetrics[i], field.valueType, v*field.scale)
+		if r.legacyClusterMetrics != nil {
+			ch <- prometheus.MustNewConstMetric(r.legacyClusterMetrics[i], prometheus.GaugeValue, v)
+		}
+	}
+	return nil
+}
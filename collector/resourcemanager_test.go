@@ -0,0 +1,251 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	clusterMetrics                 = `{"clusterMetrics":{"activeNodes":3,"rebootedNodes":0,"decommissionedNodes":0,"unhealthyNodes":0,"lostNodes":0,"totalNodes":3,"totalVirtualCores":9,"availableMB":6144,"reservedMB":0,"appsKilled":0,"appsFailed":1,"appsRunning":0,"appsPending":0,"appsCompleted":9,"appsSubmitted":10,"allocatedMB":0,"reservedVirtualCores":0,"availableVirtualCores":9,"allocatedVirtualCores":0,"containersAllocated":0,"containersReserved":0,"containersPending":0,"totalMB":6144}}`
+	scheduler                      = `{"scheduler":{"schedulerInfo":{"type":"capacityScheduler","capacity":100.0,"usedCapacity":25.0,"maxCapacity":100.0,"queueName":"root","queues":{"queue":[{"type":"capacitySchedulerLeafQueueInfo","capacity":40.0,"usedCapacity":50.0,"maxCapacity":100.0,"absoluteUsedCapacity":20.0,"numApplications":1,"numPendingApplications":0,"numContainers":2,"queueName":"default","resourcesUsed":{"memory":2048,"vCores":2}}]}}}}`
+	nodes                          = `{"nodes":{"node":[{"rack":"/default-rack","state":"RUNNING","id":"nm1:45454","lastHealthUpdate":1476995346399,"numContainers":2,"usedMemoryMB":2048,"availMemoryMB":0,"usedVirtualCores":2,"availableVirtualCores":1}]}}`
+	apps                           = `{"apps":{"app":[{"id":"application_1476912658570_0002","user":"user1","name":"word count","queue":"default","state":"RUNNING","progress":50.0,"elapsedTime":25196,"allocatedMB":2048,"allocatedVCores":2,"runningContainers":2}]}}`
+	clusterMetricsWithoutLostNodes = `{"clusterMetrics":{"activeNodes":4,"rebootedNodes":0,"decommissionedNodes":0,"unhealthyNodes":0,"totalNodes":4,"totalVirtualCores":12,"availableMB":8192,"reservedMB":0,"appsKilled":0,"appsFailed":1,"appsRunning":0,"appsPending":0,"appsCompleted":9,"appsSubmitted":10,"allocatedMB":0,"reservedVirtualCores":0,"availableVirtualCores":12,"allocatedVirtualCores":0,"containersAllocated":0,"containersReserved":0,"containersPending":0,"totalMB":8192}}`
+)
+
+// TestResourceManagerConcurrentScrapes hammers /metrics in parallel while the
+// ResourceManager alternates between two responses, one of which lacks
+// lostNodes. Run it with -race.
+func TestResourceManagerConcurrentScrapes(t *testing.T) {
+	var requests int64
+	resourcemanager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1)%2 == 0 {
+			w.Write([]byte(clusterMetricsWithoutLostNodes))
+		} else {
+			w.Write([]byte(clusterMetrics))
+		}
+	}))
+	defer resourcemanager.Close()
+
+	rm, err := NewResourceManager(Options{URL: resourcemanager.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newMetricsServer(rm)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				body, err := get(server.URL)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !strings.Contains(body, "resourcemanager_up 1") {
+					t.Errorf("scrape failed:\n%s", body)
+					return
+				}
+				if strings.Contains(body, "resourcemanager_nodes_active 4") && strings.Contains(body, "resourcemanager_nodes_lost ") {
+					t.Errorf("stale lostNodes served:\n%s", body)
+					return
+				}
+				if strings.Contains(body, "resourcemanager_nodes_active 3") != strings.Contains(body, "resourcemanager_memory_capacity_bytes 6.442450944e+09") {
+					t.Errorf("scrape mixes two responses:\n%s", body)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// TestResourceManagerCollectors runs every sub-collector, one of which
+// fails.
+func TestResourceManagerCollectors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/v1/cluster/scheduler", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(scheduler)) })
+	mux.HandleFunc("/ws/v1/cluster/nodes", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(nodes)) })
+	mux.HandleFunc("/ws/v1/cluster/apps", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(apps)) })
+	resourcemanager := httptest.NewServer(mux)
+	defer resourcemanager.Close()
+
+	rm, err := NewResourceManager(Options{
+		URL:        resourcemanager.URL,
+		Collectors: []string{"cluster_metrics", "scheduler", "nodes", "apps"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, []string{
+		`resourcemanager_up 1`,
+		`resourcemanager_scrape_collector_success{collector="cluster_metrics"} 0`,
+		`resourcemanager_scrape_collector_success{collector="scheduler"} 1`,
+		`resourcemanager_queue_capacity_ratio{queue="root"} 1`,
+		`resourcemanager_queue_absolute_used_capacity_ratio{queue="default"} 0.2`,
+		`resourcemanager_queue_memory_used_bytes{queue="default"} 2.147483648e+09`,
+		`resourcemanager_node_containers{node="nm1:45454",rack="/default-rack"} 2`,
+		`resourcemanager_node_state{node="nm1:45454",rack="/default-rack",state="RUNNING"} 1`,
+		`resourcemanager_node_last_health_update_timestamp_seconds{node="nm1:45454",rack="/default-rack"} 1.476995346399e+09`,
+		`resourcemanager_app_progress_ratio{id="application_1476912658570_0002",name="word count",queue="default",user="user1"} 0.5`,
+	}, nil, rm)
+}
+
+// TestResourceManagerQueuePath labels queues by their path, as the names of
+// leaf queues need not be unique since Hadoop 3.3.
+func TestResourceManagerQueuePath(t *testing.T) {
+	resourcemanager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"scheduler":{"schedulerInfo":{"type":"capacityScheduler","capacity":100.0,"queueName":"root","queuePath":"root","queues":{"queue":[` +
+			`{"capacity":60.0,"queueName":"a","queuePath":"root.a","queues":{"queue":[{"capacity":100.0,"queueName":"default","queuePath":"root.a.default"}]}},` +
+			`{"capacity":40.0,"queueName":"b","queuePath":"root.b","queues":{"queue":[{"capacity":50.0,"queueName":"default","queuePath":"root.b.default"}]}}]}}}}`))
+	}))
+	defer resourcemanager.Close()
+
+	rm, err := NewResourceManager(Options{URL: resourcemanager.URL, Collectors: []string{"scheduler"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMetrics(t, []string{
+		`resourcemanager_queue_capacity_ratio{queue="root"} 1`,
+		`resourcemanager_queue_capacity_ratio{queue="root.a"} 0.6`,
+		`resourcemanager_queue_capacity_ratio{queue="root.a.default"} 1`,
+		`resourcemanager_queue_capacity_ratio{queue="root.b.default"} 0.5`,
+	}, []string{
+		`queue="default"`,
+	}, rm)
+}
+
+// waitFor fails t unless cond holds within a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestResourceManagerPolling serves scrapes from the last poll and stops
+// polling with StopPolling.
+func TestResourceManagerPolling(t *testing.T) {
+	var requests int64
+	resourcemanager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		w.Write([]byte(clusterMetrics))
+	}))
+	defer resourcemanager.Close()
+
+	rm, err := NewResourceManager(Options{URL: resourcemanager.URL, Collectors: []string{"cluster_metrics"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rm.StartPolling(time.Hour)
+	defer rm.StopPolling()
+	server := newMetricsServer(rm)
+	defer server.Close()
+
+	var body string
+	waitFor(t, "the first poll", func() bool {
+		body, err = get(server.URL)
+		return err == nil && strings.Contains(body, "resourcemanager_up 1")
+	})
+	for i := 0; i < 3; i++ {
+		if body, err = get(server.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt64(&requests); n != 1 {
+		t.Errorf("%d requests to the ResourceManager, want those of the only poll", n)
+	}
+	for _, want := range []string{
+		"resourcemanager_apps_submitted_total 10",
+		`resourcemanager_scrape_collector_success{collector="cluster_metrics"} 1`,
+		"resourcemanager_poll_cache_age_seconds ",
+		"resourcemanager_poll_last_success_timestamp_seconds ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "resourcemanager_poll_last_success_timestamp_seconds 0\n") {
+		t.Errorf("time of the last successful poll not set:\n%s", body)
+	}
+
+	rm, err = NewResourceManager(Options{URL: resourcemanager.URL, Collectors: []string{"cluster_metrics"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := atomic.LoadInt64(&requests)
+	rm.StartPolling(10 * time.Millisecond)
+	waitFor(t, "two polls", func() bool { return atomic.LoadInt64(&requests) >= start+2 })
+	rm.StopPolling()
+	// A poll may still be running.
+	time.Sleep(50 * time.Millisecond)
+	stopped := atomic.LoadInt64(&requests)
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(&requests); n != stopped {
+		t.Errorf("%d polls after StopPolling", n-stopped)
+	}
+}
+
+// TestResourceManagerSharedScrapes makes concurrent scrapes while the
+// ResourceManager holds the first request, so that they share its fetch.
+func TestResourceManagerSharedScrapes(t *testing.T) {
+	var requests int64
+	release := make(chan struct{})
+	resourcemanager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) == 1 {
+			<-release
+		}
+		w.Write([]byte(clusterMetrics))
+	}))
+	defer resourcemanager.Close()
+
+	rm, err := NewResourceManager(Options{URL: resourcemanager.URL, Collectors: []string{"cluster_metrics"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newMetricsServer(rm)
+	defer server.Close()
+
+	const scrapes = 4
+	var wg sync.WaitGroup
+	for i := 0; i < scrapes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, err := get(server.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !strings.Contains(body, "resourcemanager_apps_submitted_total 10") {
+				t.Errorf("scrape failed:\n%s", body)
+			}
+		}()
+	}
+	waitFor(t, "the first request", func() bool { return atomic.LoadInt64(&requests) == 1 })
+	// Let the other scrapes join the fetch in flight.
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt64(&requests); n != 1 {
+		t.Errorf("%d requests to the ResourceManager for %d concurrent scrapes, want 1", n, scrapes)
+	}
+
+	expectMetrics(t, []string{fmt.Sprintf("resourcemanager_scrape_shared_total %d", scrapes-1)}, nil, rm)
+	if n := atomic.LoadInt64(&requests); n != 2 {
+		t.Errorf("a later scrape made %d requests, want its own one", n-1)
+	}
+}
@@ -0,0 +1,244 @@
+package collector
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+/*
+  "scheduler": {
+    "schedulerInfo": {
+      "type": "capacityScheduler",
+      "capacity": 100.0,
+      "usedCapacity": 0.0,
+      "maxCapacity": 100.0,
+      "queueName": "root",
+      "queues": {
+        "queue": [
+          {
+            "type": "capacitySchedulerLeafQueueInfo",
+            "capacity": 100.0,
+            "usedCapacity": 0.0,
+            "maxCapacity": 100.0,
+            "absoluteUsedCapacity": 0.0,
+            "numApplications": 0,
+            "numPendingApplications": 0,
+            "numContainers": 0,
+            "queueName": "default",
+            "queuePath": "root.default",
+            "state": "RUNNING",
+            "resourcesUsed": {"memory": 0, "vCores": 0},
+            ...
+          }
+        ]
+      }
+    }
+  }
+*/
+
+// queueFields are the fields of the CapacityScheduler queues of
+// /ws/v1/cluster/scheduler and the metrics they are exported as. Parent
+// queues lack some of them.
+var queueFields = []restField{
+	{"capacity", "queue_capacity_ratio", "Configured capacity of the queue relative to its parent.", prometheus.GaugeValue, 0.01},
+	{"maxCapacity", "queue_max_capacity_ratio", "Maximum capacity of the queue relative to its parent.", prometheus.GaugeValue, 0.01},
+	{"usedCapacity", "queue_used_capacity_ratio", "Used capacity of the queue relative to its configured capacity.", prometheus.GaugeValue, 0.01},
+	{"absoluteUsedCapacity", "queue_absolute_used_capacity_ratio", "Used capacity of the queue relative to the cluster.", prometheus.GaugeValue, 0.01},
+	{"numApplications", "queue_apps", "Number of applications in the queue.", prometheus.GaugeValue, 1},
+	{"numPendingApplications", "queue_apps_pending", "Number of pending applications in the queue.", prometheus.GaugeValue, 1},
+	{"numContainers", "queue_containers", "Number of containers of the queue.", prometheus.GaugeValue, 1},
+	{"resourcesUsed.memory", "queue_memory_used_bytes", "Memory used by the queue.", prometheus.GaugeValue, mb},
+	{"resourcesUsed.vCores", "queue_vcores_used", "Virtual cores used by the queue.", prometheus.GaugeValue, 1},
+}
+
+/*
+  "nodes": {
+    "node": [
+      {
+        "rack": "/default-rack",
+        "state": "RUNNING",
+        "id": "nm1.example.com:45454",
+        "nodeHostName": "nm1.example.com",
+        "nodeHTTPAddress": "nm1.example.com:8042",
+        "lastHealthUpdate": 1476995346399,
+        "healthReport": "",
+        "numContainers": 0,
+        "usedMemoryMB": 0,
+        "availMemoryMB": 2048,
+        "usedVirtualCores": 0,
+        "availableVirtualCores": 3
+      }
+    ]
+  }
+*/
+
+// nodeFields are the fields of the NodeManagers of /ws/v1/cluster/nodes and
+// the metrics they are exported as.
+var nodeFields = []restField{
+	{"numContainers", "node_containers", "Number of containers on the NodeManager.", prometheus.GaugeValue, 1},
+	{"usedMemoryMB", "node_memory_used_bytes", "Memory of the NodeManager used by containers.", prometheus.GaugeValue, mb},
+	{"availMemoryMB", "node_memory_available_bytes", "Memory of the NodeManager available for containers.", prometheus.GaugeValue, mb},
+	{"usedVirtualCores", "node_vcores_used", "Virtual cores of the NodeManager used by containers.", prometheus.GaugeValue, 1},
+	{"availableVirtualCores", "node_vcores_available", "Virtual cores of the NodeManager available for containers.", prometheus.GaugeValue, 1},
+	{"lastHealthUpdate", "node_last_health_update_timestamp_seconds", "Time of the last health report of the NodeManager.", prometheus.GaugeValue, 0.001},
+}
+
+/*
+  "apps": {
+    "app": [
+      {
+        "id": "application_1476912658570_0002",
+        "user": "user1",
+        "name": "word count",
+        "queue": "default",
+        "state": "RUNNING",
+        "progress": 50.0,
+        "elapsedTime": 25196,
+        "allocatedMB": 2048,
+        "allocatedVCores": 2,
+        "runningContainers": 2,
+        ...
+      }
+    ]
+  }
+*/
+
+// appFields are the fields of the applications of /ws/v1/cluster/apps and
+// the metrics they are exported as.
+var appFields = []restField{
+	{"allocatedMB", "app_memory_allocated_bytes", "Memory allocated to the containers of the application.", prometheus.GaugeValue, mb},
+	{"allocatedVCores", "app_vcores_allocated", "Virtual cores allocated to the containers of the application.", prometheus.GaugeValue, 1},
+	{"runningContainers", "app_containers_running", "Number of running containers of the application.", prometheus.GaugeValue, 1},
+	{"progress", "app_progress_ratio", "Progress of the application.", prometheus.GaugeValue, 0.01},
+	{"elapsedTime", "app_elapsed_seconds", "Time since the application started.", prometheus.GaugeValue, 0.001},
+}
+
+// restMetrics are the metrics made of fields of objects of the same kind,
+// such as the NodeManagers, labelled by the object.
+type restMetrics struct {
+	fields []restField
+	descs  []*prometheus.Desc
+}
+
+func (r *ResourceManager) newRESTMetrics(fields []restField, labels ...string) *restMetrics {
+	m := &restMetrics{fields: fields}
+	for _, f := range fields {
+		m.descs = append(m.descs, r.newDesc(f.name, f.help, labels))
+	}
+	return m
+}
+
+// send sends the metrics of the fields of object that are numbers.
+func (m *restMetrics) send(ch chan<- prometheus.Metric, object map[string]interface{}, labelValues ...string) {
+	for i, f := range m.fields {
+		if v, ok := lookup(object, f.field); ok {
+			ch <- prometheus.MustNewConstMetric(m.descs[i], f.valueType, v*f.scale, labelValues...)
+		}
+	}
+}
+
+// lookup returns the number at path in object, e.g. "resourcesUsed.memory".
+func lookup(object map[string]interface{}, path string) (float64, bool) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := object[part].(map[string]interface{})
+		if !ok {
+			return 0, false
+		}
+		object = child
+	}
+	v, ok := object[parts[len(parts)-1]].(float64)
+	return v, ok
+}
+
+// str returns the string field of object, or "" if absent.
+func str(object map[string]interface{}, field string) string {
+	s, _ := object[field].(string)
+	return s
+}
+
+// newSchedulerCollector returns the scheduler sub-collector, which exports
+// the queues of the CapacityScheduler, labelled by queue path, starting with
+// the root queue. Before Hadoop 3.3 there is no queuePath and the queue name,
+// then unique, is used. Other schedulers are not supported and yield no
+// metrics.
+func (r *ResourceManager) newSchedulerCollector() scrape.CollectFunc {
+	queues := r.newRESTMetrics(queueFields, "queue")
+	var walk func(ch chan<- prometheus.Metric, queue map[string]interface{})
+	walk = func(ch chan<- prometheus.Metric, queue map[string]interface{}) {
+		name := str(queue, "queuePath")
+		if name == "" {
+			name = str(queue, "queueName")
+		}
+		queues.send(ch, queue, name)
+		children, _ := queue["queues"].(map[string]interface{})
+		list, _ := children["queue"].([]interface{})
+		for _, child := range list {
+			if c, ok := child.(map[string]interface{}); ok {
+				walk(ch, c)
+			}
+		}
+	}
+	return func(ctx context.Context, ch chan<- prometheus.Metric) error {
+		var f struct {
+			Scheduler struct {
+				SchedulerInfo map[string]interface{} `json:"schedulerInfo"`
+			} `json:"scheduler"`
+		}
+		if err := r.get(ctx, "scheduler", "/ws/v1/cluster/scheduler", &f); err != nil {
+			return err
+		}
+		if str(f.Scheduler.SchedulerInfo, "type") == "capacityScheduler" {
+			walk(ch, f.Scheduler.SchedulerInfo)
+		}
+		return nil
+	}
+}
+
+// newNodesCollector returns the nodes sub-collector, which exports the
+// NodeManagers labelled by their ID and rack.
+func (r *ResourceManager) newNodesCollector() scrape.CollectFunc {
+	nodes := r.newRESTMetrics(nodeFields, "node", "rack")
+	state := r.newDesc("node_state", "State of the NodeManager, 1 for its current state.", []string{"node", "rack", "state"})
+	return func(ctx context.Context, ch chan<- prometheus.Metric) error {
+		var f struct {
+			Nodes struct {
+				Node []map[string]interface{} `json:"node"`
+			} `json:"nodes"`
+		}
+		if err := r.get(ctx, "nodes", "/ws/v1/cluster/nodes", &f); err != nil {
+			return err
+		}
+		for _, node := range f.Nodes.Node {
+			id, rack := str(node, "id"), str(node, "rack")
+			nodes.send(ch, node, id, rack)
+			if s := str(node, "state"); s != "" {
+				ch <- prometheus.MustNewConstMetric(state, prometheus.GaugeValue, 1, id, rack, s)
+			}
+		}
+		return nil
+	}
+}
+
+// newAppsCollector returns the apps sub-collector, which exports the running
+// applications labelled by their ID, name, queue and user.
+func (r *ResourceManager) newAppsCollector() scrape.CollectFunc {
+	apps := r.newRESTMetrics(appFields, "id", "name", "queue", "user")
+	return func(ctx context.Context, ch chan<- prometheus.Metric) error {
+		var f struct {
+			Apps struct {
+				App []map[string]interface{} `json:"app"`
+			} `json:"apps"`
+		}
+		if err := r.get(ctx, "apps", "/ws/v1/cluster/apps?states=RUNNING", &f); err != nil {
+			return err
+		}
+		for _, app := range f.Apps.App {
+			apps.send(ch, app, str(app, "id"), str(app, "name"), str(app, "queue"), str(app, "user"))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,528 @@
+// Package config reads the YAML configuration file of hadoop_exporter, which
+// describes the daemons to export the metrics of and how:
+//
+//	listen_address: ":9070"
+//	labels:
+//	  cluster: prod
+//	namenode:
+//	  url: http://namenode:50070/jmx
+//	  basic_auth:
+//	    username: exporter
+//	    password_file: /etc/hadoop_exporter/password
+//	  collectors: [fsnamesystem, jvm]
+//	  tag_labels: [Hostname]
+//	resourcemanager:
+//	  url: http://resourcemanager:8088
+//	modules:
+//	  secure_namenode:
+//	    role: namenode
+//	    timeout: 5s
+//	    basic_auth:
+//	      username: exporter
+//	      password: secret
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wyukawa/hadoop_exporter/collector"
+	"github.com/wyukawa/hadoop_exporter/jmx"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the whole configuration file. A role runs if its section is
+// present.
+type Config struct {
+	// ListenAddress is where metrics are served. It is only read at
+	// startup.
+	ListenAddress string `yaml:"listen_address"`
+	// Labels are added to every metric of the roles.
+	Labels map[string]string `yaml:"labels"`
+	// LegacyNames also exports the metrics under the names of earlier
+	// versions.
+	LegacyNames bool `yaml:"legacy_names"`
+
+	NameNode        *NameNode        `yaml:"namenode"`
+	ResourceManager *ResourceManager `yaml:"resourcemanager"`
+	JMX             *JMX             `yaml:"jmx"`
+
+	// Clusters are further daemons whose metrics carry a cluster label.
+	Clusters []*Cluster `yaml:"clusters"`
+
+	// Modules configure how the targets of /probe requests are scraped, by
+	// module name.
+	Modules map[string]*Module `yaml:"modules"`
+}
+
+// Cluster is a set of daemons exported with the label cluster="<Name>".
+type Cluster struct {
+	Name string `yaml:"name"`
+	// Labels are added to the metrics of the daemons of the cluster.
+	Labels map[string]string `yaml:"labels"`
+	// Timeout is the timeout of the daemons that do not set their own.
+	Timeout time.Duration `yaml:"timeout"`
+
+	NameNode        *NameNode        `yaml:"namenode"`
+	ResourceManager *ResourceManager `yaml:"resourcemanager"`
+	JMX             *JMX             `yaml:"jmx"`
+}
+
+// ForCluster returns a configuration whose roles are the daemons of cl and
+// whose labels are those of c and cl plus the cluster label.
+func (c *Config) ForCluster(cl *Cluster) *Config {
+	labels := map[string]string{}
+	for name, value := range c.Labels {
+		labels[name] = value
+	}
+	for name, value := range cl.Labels {
+		labels[name] = value
+	}
+	labels["cluster"] = cl.Name
+	cc := &Config{Labels: labels, LegacyNames: c.LegacyNames}
+	if cl.NameNode != nil {
+		nn := *cl.NameNode
+		nn.Target = cl.withTimeout(nn.Target)
+		cc.NameNode = &nn
+	}
+	if cl.ResourceManager != nil {
+		rm := *cl.ResourceManager
+		rm.Target = cl.withTimeout(rm.Target)
+		cc.ResourceManager = &rm
+	}
+	if cl.JMX != nil {
+		j := *cl.JMX
+		j.Target = cl.withTimeout(j.Target)
+		cc.JMX = &j
+	}
+	return cc
+}
+
+func (cl *Cluster) withTimeout(t Target) Target {
+	if t.Timeout == 0 {
+		t.Timeout = cl.Timeout
+	}
+	return t
+}
+
+// Module configures the scrapes of /probe requests naming it. Its URL is
+// empty, the target comes from the request.
+type Module struct {
+	// Role is the kind of daemon, namenode, resourcemanager or jmx. It
+	// defaults to the name of the module.
+	Role      string `yaml:"role"`
+	JMXTarget `yaml:",inline"`
+	// Namespace prefixes the metric names of the jmx role.
+	Namespace string `yaml:"namespace"`
+	// MaxDataNodes limits the DataNodes exported by the namenode role, see
+	// NameNode.
+	MaxDataNodes int `yaml:"max_datanodes"`
+}
+
+// roleCollectors are the sub-collectors of the roles.
+var roleCollectors = map[string][]collector.SubCollector{
+	"namenode":        collector.NameNodeCollectors(),
+	"resourcemanager": collector.ResourceManagerCollectors(),
+	"jmx":             nil,
+}
+
+// Module returns the module called name. The roles are also modules with
+// the default settings unless configured otherwise.
+func (c *Config) Module(name string) (*Module, bool) {
+	if m, ok := c.Modules[name]; ok {
+		return m, true
+	}
+	if _, ok := roleCollectors[name]; ok {
+		return &Module{Role: name}, true
+	}
+	return nil, false
+}
+
+// ForTarget returns a configuration whose only role is that of module m,
+// fetching from target.
+func (c *Config) ForTarget(m *Module, target string) *Config {
+	t := m.JMXTarget
+	t.URL = target
+	probe := &Config{Labels: c.Labels, LegacyNames: c.LegacyNames}
+	switch m.Role {
+	case "namenode":
+		probe.NameNode = &NameNode{JMXTarget: t, MaxDataNodes: m.MaxDataNodes}
+	case "resourcemanager":
+		probe.ResourceManager = &ResourceManager{t.Target}
+	case "jmx":
+		probe.JMX = &JMX{t, m.Namespace}
+	}
+	return probe
+}
+
+// Target is a daemon to fetch from.
+type Target struct {
+	// URL is the address of the daemon, see collector.Options.
+	URL       string     `yaml:"url"`
+	BasicAuth *BasicAuth `yaml:"basic_auth"`
+	// Labels are added to the metrics of this daemon.
+	Labels map[string]string `yaml:"labels"`
+	// Collectors are the sub-collectors to enable, the default ones if
+	// absent.
+	Collectors []string `yaml:"collectors"`
+	// Timeout, if set, bounds a request to the daemon, including retries,
+	// instead of -upstream.read-timeout.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// BasicAuth are the credentials sent to a daemon.
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PasswordFile, if set, is read for the password whenever the
+	// configuration is applied.
+	PasswordFile string `yaml:"password_file"`
+}
+
+// GetPassword returns the password, reading it from PasswordFile if set.
+func (a *BasicAuth) GetPassword() (string, error) {
+	if a.PasswordFile == "" {
+		return a.Password, nil
+	}
+	data, err := ioutil.ReadFile(a.PasswordFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// JMXTarget is a daemon whose metrics come from its JMX JSON servlet.
+type JMXTarget struct {
+	Target `yaml:",inline"`
+	// RulesFile is the path of a JSON file with mapping rules, see
+	// jmx.LoadRules.
+	RulesFile string `yaml:"rules_file"`
+	// Auto, if present, exports the attributes that no rule matches.
+	Auto *jmx.Auto `yaml:"auto"`
+	// TagLabels are the tags of the beans to add as labels.
+	TagLabels []string `yaml:"tag_labels"`
+	// MaxResponseBytes is the largest /jmx response read, 64 MiB if
+	// absent and no limit if 0.
+	MaxResponseBytes *int64 `yaml:"max_response_bytes"`
+}
+
+// GetMaxResponseBytes returns MaxResponseBytes or its default.
+func (t *JMXTarget) GetMaxResponseBytes() int64 {
+	if t.MaxResponseBytes == nil {
+		return 64 << 20
+	}
+	return *t.MaxResponseBytes
+}
+
+// NameNode configures the namenode role.
+type NameNode struct {
+	JMXTarget `yaml:",inline"`
+	// NameNodes, if set instead of URL, are the URLs of the JMX servlets
+	// of the NameNodes of an HA nameservice by NameNode ID, see
+	// collector.NameNodeHAOptions.
+	NameNodes map[string]string `yaml:"namenodes"`
+	// Nameservices, if set instead of URL and NameNodes, are the NameNodes
+	// of the nameservices of a federated cluster by nameservice ID, see
+	// collector.NameNodeFederationOptions.
+	Nameservices map[string]map[string]string `yaml:"nameservices"`
+	// IsActiveServlet makes the HA state of NameNodes be read from their
+	// /isActive servlet.
+	IsActiveServlet bool `yaml:"is_active_servlet"`
+	// MaxDataNodes, if positive, limits the number of DataNodes exported
+	// by the datanode_details collector.
+	MaxDataNodes int `yaml:"max_datanodes"`
+}
+
+func (n *NameNode) validate(available []collector.SubCollector) error {
+	if n.MaxDataNodes < 0 {
+		return errors.New("max_datanodes must not be negative")
+	}
+	given := 0
+	for _, set := range []bool{n.URL != "", len(n.NameNodes) > 0, len(n.Nameservices) > 0} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return errors.New("only one of url, namenodes and nameservices may be given")
+	}
+	switch {
+	case len(n.NameNodes) > 0:
+		return n.validateNameNodes(n.NameNodes, available)
+	case len(n.Nameservices) > 0:
+		for ns, namenodes := range n.Nameservices {
+			if len(namenodes) == 0 {
+				return fmt.Errorf("nameservice %s: no namenodes", ns)
+			}
+			if err := n.validateNameNodes(namenodes, available); err != nil {
+				return fmt.Errorf("nameservice %s: %s", ns, err)
+			}
+		}
+		return nil
+	}
+	if n.IsActiveServlet {
+		return errors.New("is_active_servlet needs namenodes or nameservices")
+	}
+	return n.Target.validate(available)
+}
+
+func (n *NameNode) validateNameNodes(namenodes map[string]string, available []collector.SubCollector) error {
+	for id, u := range namenodes {
+		t := n.Target
+		t.URL = u
+		if err := t.validate(available); err != nil {
+			return fmt.Errorf("namenode %s: %s", id, err)
+		}
+	}
+	return nil
+}
+
+// ResourceManager configures the resourcemanager role.
+type ResourceManager struct {
+	Target `yaml:",inline"`
+}
+
+// JMX configures the jmx role. Its collector has no sub-collectors.
+type JMX struct {
+	JMXTarget `yaml:",inline"`
+	// Namespace prefixes the metric names, "hadoop" if empty.
+	Namespace string `yaml:"namespace"`
+}
+
+// Parse parses and validates a configuration. Unknown fields are errors.
+func Parse(data []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Validate checks that the configuration can be applied as far as it can
+// be told without contacting the daemons or reading other files.
+func (c *Config) Validate() error {
+	if c.NameNode == nil && c.ResourceManager == nil && c.JMX == nil && len(c.Clusters) == 0 && len(c.Modules) == 0 {
+		return errors.New("no role configured, add a namenode, resourcemanager, jmx, clusters or modules section")
+	}
+	if err := validateLabels(c.Labels); err != nil {
+		return err
+	}
+	if err := c.validateRoles(); err != nil {
+		return err
+	}
+	if len(c.Clusters) > 0 && (c.NameNode != nil || c.ResourceManager != nil || c.JMX != nil) {
+		return errors.New("clusters cannot be combined with top-level namenode, resourcemanager or jmx sections, move those into a cluster")
+	}
+	names := map[string]bool{}
+	// labelNames are the label names of the metrics of each role, which
+	// must be the same in every cluster.
+	labelNames := map[string]string{}
+	for i, cl := range c.Clusters {
+		if cl == nil || cl.Name == "" {
+			return fmt.Errorf("cluster %d: no name", i+1)
+		}
+		if names[cl.Name] {
+			return fmt.Errorf("cluster %s: defined twice", cl.Name)
+		}
+		names[cl.Name] = true
+		if err := cl.validate(); err != nil {
+			return fmt.Errorf("cluster %s: %s", cl.Name, err)
+		}
+		cc := c.ForCluster(cl)
+		if err := cc.validateRoles(); err != nil {
+			return fmt.Errorf("cluster %s: %s", cl.Name, err)
+		}
+		for role, ls := range cc.labelNames() {
+			key := strings.Join(ls, ",")
+			if other, ok := labelNames[role]; ok && other != key {
+				return fmt.Errorf("cluster %s: the labels of the %s (%s) differ from those in other clusters (%s)", cl.Name, role, key, other)
+			}
+			labelNames[role] = key
+		}
+	}
+	for name, m := range c.Modules {
+		if m == nil {
+			return fmt.Errorf("module %s: empty", name)
+		}
+		if err := m.validate(name); err != nil {
+			return fmt.Errorf("module %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func (cl *Cluster) validate() error {
+	if cl.NameNode == nil && cl.ResourceManager == nil && cl.JMX == nil {
+		return errors.New("no role configured, add a namenode, resourcemanager or jmx section")
+	}
+	if _, ok := cl.Labels["cluster"]; ok {
+		return errors.New("the cluster label is set by the name of the cluster")
+	}
+	if cl.Timeout < 0 {
+		return errors.New("negative timeout")
+	}
+	return validateLabels(cl.Labels)
+}
+
+// targets returns the daemons of the roles configured in c by role.
+func (c *Config) targets() map[string]*Target {
+	targets := map[string]*Target{}
+	if c.NameNode != nil {
+		targets["namenode"] = &c.NameNode.Target
+	}
+	if c.ResourceManager != nil {
+		targets["resourcemanager"] = &c.ResourceManager.Target
+	}
+	if c.JMX != nil {
+		targets["jmx"] = &c.JMX.Target
+	}
+	return targets
+}
+
+// labelNames returns the sorted names of the labels of the metrics of each
+// role configured in c: the constant labels, the labels the collectors add
+// for the NameNodes of HA and federated clusters, and, prefixed with
+// "tag.", the tags of the beans added as labels.
+func (c *Config) labelNames() map[string][]string {
+	names := map[string][]string{}
+	for role, t := range c.targets() {
+		set := map[string]bool{}
+		for name := range c.Labels {
+			set[name] = true
+		}
+		for name := range t.Labels {
+			set[name] = true
+		}
+		var tags []string
+		switch role {
+		case "namenode":
+			tags = c.NameNode.TagLabels
+			if len(c.NameNode.NameNodes) > 0 || len(c.NameNode.Nameservices) > 0 {
+				set["nn_id"] = true
+				set["tag.HAState"] = true
+			}
+			if len(c.NameNode.Nameservices) > 0 {
+				set["nameservice"] = true
+				set["block_pool_id"] = true
+			}
+		case "jmx":
+			tags = c.JMX.TagLabels
+		}
+		for _, tag := range tags {
+			set["tag."+tag] = true
+		}
+		var ls []string
+		for name := range set {
+			ls = append(ls, name)
+		}
+		sort.Strings(ls)
+		names[role] = ls
+	}
+	return names
+}
+
+// validateRoles checks the role sections of c.
+func (c *Config) validateRoles() error {
+	if c.NameNode != nil {
+		if err := c.NameNode.validate(collector.NameNodeCollectors()); err != nil {
+			return fmt.Errorf("namenode: %s", err)
+		}
+	}
+	if c.ResourceManager != nil {
+		if err := c.ResourceManager.validate(collector.ResourceManagerCollectors()); err != nil {
+			return fmt.Errorf("resourcemanager: %s", err)
+		}
+	}
+	if c.JMX != nil {
+		if err := c.JMX.validate(nil); err != nil {
+			return fmt.Errorf("jmx: %s", err)
+		}
+	}
+	return nil
+}
+
+// validate checks the module called name and defaults its role.
+func (m *Module) validate(name string) error {
+	if m.Role == "" {
+		m.Role = name
+	}
+	available, ok := roleCollectors[m.Role]
+	if !ok {
+		return fmt.Errorf("unknown role %q, want namenode, resourcemanager or jmx", m.Role)
+	}
+	if m.URL != "" {
+		return errors.New("url is given by the target parameter of /probe")
+	}
+	if m.Role == "resourcemanager" && (m.RulesFile != "" || m.Auto != nil || m.TagLabels != nil || m.MaxResponseBytes != nil) {
+		return errors.New("rules_file, auto, tag_labels and max_response_bytes only apply to the namenode and jmx roles")
+	}
+	if m.Namespace != "" && m.Role != "jmx" {
+		return errors.New("namespace only applies to the jmx role")
+	}
+	if m.MaxDataNodes != 0 && m.Role != "namenode" {
+		return errors.New("max_datanodes only applies to the namenode role")
+	}
+	if m.MaxDataNodes < 0 {
+		return errors.New("max_datanodes must not be negative")
+	}
+	return m.Target.validateSettings(available)
+}
+
+func (t *Target) validate(available []collector.SubCollector) error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %s", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid url %q, want http://host:port/...", t.URL)
+	}
+	return t.validateSettings(available)
+}
+
+// validateSettings checks the target except for its URL.
+func (t *Target) validateSettings(available []collector.SubCollector) error {
+	if t.Timeout < 0 {
+		return errors.New("negative timeout")
+	}
+	if a := t.BasicAuth; a != nil {
+		if a.Username == "" {
+			return errors.New("basic_auth needs a username")
+		}
+		if a.Password != "" && a.PasswordFile != "" {
+			return errors.New("basic_auth has both password and password_file")
+		}
+	}
+	if err := validateLabels(t.Labels); err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, c := range available {
+		known[c.Name] = true
+	}
+	for _, name := range t.Collectors {
+		if !known[name] {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	return nil
+}
+
+func validateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
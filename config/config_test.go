@@ -0,0 +1,132 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`
+listen_address: ":9070"
+labels:
+  cluster: prod
+namenode:
+  url: http://namenode:50070/jmx
+  basic_auth:
+    username: exporter
+    password: secret
+  collectors: [fsnamesystem, jvm]
+  auto:
+    deny_beans: ["java.lang:type=MemoryPool,.*"]
+  max_response_bytes: 0
+resourcemanager:
+  url: http://resourcemanager:8088
+  collectors: []
+modules:
+  secure_rm:
+    role: resourcemanager
+    timeout: 5s
+    basic_auth: {username: exporter, password: secret}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nn := c.NameNode
+	if nn.URL != "http://namenode:50070/jmx" || nn.BasicAuth.Username != "exporter" || len(nn.Collectors) != 2 {
+		t.Errorf("unexpected namenode section %+v", nn)
+	}
+	if nn.Auto == nil || len(nn.Auto.DenyBeans) != 1 || nn.GetMaxResponseBytes() != 0 {
+		t.Errorf("unexpected namenode section %+v", nn)
+	}
+	if rm := c.ResourceManager; rm.Collectors == nil || len(rm.Collectors) != 0 {
+		t.Errorf("empty collectors not kept: %#v", rm.Collectors)
+	}
+	if c.JMX != nil {
+		t.Error("jmx role configured")
+	}
+
+	m, ok := c.Module("secure_rm")
+	if !ok || m.Timeout != 5*time.Second {
+		t.Fatalf("unexpected module %+v", m)
+	}
+	probe := c.ForTarget(m, "http://rm2:8088")
+	if probe.NameNode != nil || probe.ResourceManager.URL != "http://rm2:8088" || probe.ResourceManager.BasicAuth.Password != "secret" || probe.Labels["cluster"] != "prod" {
+		t.Errorf("unexpected probe configuration %+v", probe)
+	}
+	if m, ok := c.Module("namenode"); !ok || m.Role != "namenode" {
+		t.Errorf("no default namenode module: %+v", m)
+	}
+	if _, ok := c.Module("datanode"); ok {
+		t.Error("unknown module found")
+	}
+}
+
+func TestClusters(t *testing.T) {
+	c, err := Parse([]byte(`
+labels: {env: prod}
+clusters:
+  - name: a
+    timeout: 5s
+    namenode:
+      url: http://nn-a:50070/jmx
+      timeout: 2s
+    resourcemanager:
+      url: http://rm-a:8088
+  - name: b
+    namenode:
+      url: http://nn-b:50070/jmx
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := c.ForCluster(c.Clusters[0])
+	if a.Labels["cluster"] != "a" || a.Labels["env"] != "prod" {
+		t.Errorf("unexpected labels %v", a.Labels)
+	}
+	if a.NameNode.Timeout != 2*time.Second || a.ResourceManager.Timeout != 5*time.Second {
+		t.Errorf("unexpected timeouts %v, %v", a.NameNode.Timeout, a.ResourceManager.Timeout)
+	}
+	if c.Clusters[0].ResourceManager.Timeout != 0 {
+		t.Error("cluster timeout written into the parsed configuration")
+	}
+	if b := c.ForCluster(c.Clusters[1]); b.ResourceManager != nil || b.NameNode.URL != "http://nn-b:50070/jmx" {
+		t.Errorf("unexpected cluster b %+v", b)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		config, err string
+	}{
+		{`labels: {cluster: prod}`, "no role configured"},
+		{"namenode:\n  url: namenode:50070", "invalid url"},
+		{"namenode:\n  url: http://namenode:50070/jmx\n  collectors: [nodes]", `unknown collector "nodes"`},
+		{"jmx:\n  url: http://datanode:50075/jmx\n  collectors: [jvm]", `unknown collector "jvm"`},
+		{"resourcemanager:\n  url: http://rm:8088\n  labels: {0bad: x}", "invalid label name"},
+		{"resourcemanager:\n  url: http://rm:8088\n  basic_auth: {password: x}", "needs a username"},
+		{"resourcemanager:\n  uri: http://rm:8088", "not found"},
+		{"modules:\n  dn: {timeout: 5s}", `unknown role "dn"`},
+		{"namenode:\n  url: http://nn:50070/jmx\n  namenodes: {nn1: http://nn1:50070/jmx}", "only one of url, namenodes and nameservices"},
+		{"namenode:\n  url: http://nn:50070/jmx\n  max_datanodes: -1", "max_datanodes must not be negative"},
+		{"modules:\n  resourcemanager: {max_datanodes: 10}", "max_datanodes only applies to the namenode role"},
+		{"namenode:\n  nameservices: {ns1: {}}", "nameservice ns1: no namenodes"},
+		{"namenode:\n  url: http://nn:50070/jmx\n  is_active_servlet: true", "is_active_servlet needs namenodes or nameservices"},
+		{"namenode:\n  namenodes: {nn1: http://nn1:50070/jmx, nn2: nn2}", "namenode nn2: invalid url"},
+		{"clusters:\n  - namenode: {url: http://nn:50070/jmx}", "cluster 1: no name"},
+		{"clusters:\n  - {name: a, namenode: {url: http://nn:50070/jmx}}\n  - {name: a, namenode: {url: http://nn2:50070/jmx}}", "defined twice"},
+		{"clusters:\n  - {name: a, labels: {cluster: x}, namenode: {url: http://nn:50070/jmx}}", "set by the name"},
+		{"clusters:\n  - {name: a, namenode: {url: http://nn:50070/jmx}}\n  - {name: b, labels: {dc: x}, namenode: {url: http://nn2:50070/jmx}}", "differ from those in other clusters"},
+		{"clusters:\n  - {name: a, namenode: {namenodes: {nn1: http://nn1:50070/jmx}}}\n  - {name: b, namenode: {url: http://nn2:50070/jmx}}", "differ from those in other clusters"},
+		{"clusters:\n  - {name: a, namenode: {namenodes: {nn1: http://nn1:50070/jmx}}}\n  - {name: b, namenode: {nameservices: {ns1: {nn1: http://nn2:50070/jmx}}}}", "differ from those in other clusters"},
+		{"clusters:\n  - {name: a, namenode: {url: http://nn1:50070/jmx, tag_labels: [Hostname]}}\n  - {name: b, namenode: {url: http://nn2:50070/jmx}}", "differ from those in other clusters"},
+		{"namenode: {url: http://nn:50070/jmx}\nclusters:\n  - {name: a, resourcemanager: {url: http://rm:8088}}", "cannot be combined"},
+		{"modules:\n  namenode: {url: http://nn:50070/jmx}", "url is given by the target"},
+		{"modules:\n  rm: {role: resourcemanager, tag_labels: [Hostname]}", "only apply to the namenode and jmx roles"},
+	} {
+		_, err := Parse([]byte(tc.config))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: got error %v, want %q", tc.config, err, tc.err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wyukawa/hadoop_exporter/collector"
+	"github.com/wyukawa/hadoop_exporter/jmx"
+)
+
+// stringsFlag is a flag that can be given several times.
+type stringsFlag []string
+
+// newStringsFlag defines a stringsFlag with the given name and usage.
+func newStringsFlag(name, usage string) *stringsFlag {
+	f := &stringsFlag{}
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// mapFlag is a flag that adds a key=value pair each time it is given.
+type mapFlag map[string]string
+
+// newMapFlag defines a mapFlag with the given name and usage.
+func newMapFlag(name, usage string) mapFlag {
+	f := mapFlag{}
+	flag.Var(f, name, usage)
+	return f
+}
+
+func (f mapFlag) String() string {
+	var pairs []string
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f mapFlag) Set(pair string) error {
+	i := strings.Index(pair, "=")
+	if i <= 0 {
+		return fmt.Errorf("%q is not of the form key=value", pair)
+	}
+	f[pair[:i]] = pair[i+1:]
+	return nil
+}
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// labelsFlag is a flag that adds a name=value label each time it is given.
+type labelsFlag prometheus.Labels
+
+func (f labelsFlag) String() string {
+	var pairs []string
+	for name, value := range f {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f labelsFlag) Set(pair string) error {
+	i := strings.Index(pair, "=")
+	if i < 0 {
+		return fmt.Errorf("label %q is not of the form name=value", pair)
+	}
+	name := pair[:i]
+	if !labelName.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+	f[name] = pair[i+1:]
+	return nil
+}
+
+// autoFlags set up the automatic export of the attributes of a role that no
+// rule matches.
+type autoFlags struct {
+	enabled *bool
+	auto    jmx.Auto
+}
+
+// registerAutoFlags registers the flags <prefix>.auto,
+// <prefix>.auto.allow-bean and so on. enabled is the default of
+// <prefix>.auto.
+func registerAutoFlags(prefix string, enabled bool) *autoFlags {
+	f := &autoFlags{
+		enabled: flag.Bool(prefix+".auto", enabled, "Export every numeric attribute that no rule matches, named after its bean."),
+	}
+	flag.Var((*stringsFlag)(&f.auto.AllowBeans), prefix+".auto.allow-bean", "Regular expression matching the names of the beans to export automatically. May be repeated; all beans if not given.")
+	flag.Var((*stringsFlag)(&f.auto.DenyBeans), prefix+".auto.deny-bean", "Regular expression matching the names of beans not to export automatically. May be repeated.")
+	flag.Var((*stringsFlag)(&f.auto.AllowAttributes), prefix+".auto.allow-attribute", "Regular expression matching the names of the attributes to export automatically. May be repeated; all attributes if not given.")
+	flag.Var((*stringsFlag)(&f.auto.DenyAttributes), prefix+".auto.deny-attribute", "Regular expression matching the names of attributes not to export automatically. May be repeated.")
+	return f
+}
+
+// get returns the automatic export settings, or nil if it is disabled.
+func (f *autoFlags) get() *jmx.Auto {
+	if !*f.enabled {
+		return nil
+	}
+	return &f.auto
+}
+
+// collectorFlag is the flag -collector.<name> or -no-collector.<name>. It
+// records whether it was given, as the default depends on the role.
+type collectorFlag struct {
+	set, value bool
+}
+
+func (f *collectorFlag) IsBoolFlag() bool { return true }
+
+func (f *collectorFlag) String() string {
+	if f == nil {
+		return "false"
+	}
+	return strconv.FormatBool(f.value)
+}
+
+func (f *collectorFlag) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	f.set, f.value = true, v
+	return nil
+}
+
+// collectorSwitch are the flags of the sub-collectors called name of every
+// role.
+type collectorSwitch struct {
+	enable, disable collectorFlag
+}
+
+// collectorSwitches are the registered collectorSwitches by name.
+var collectorSwitches = map[string]*collectorSwitch{}
+
+// enabled reports whether the flags enable a sub-collector enabled by
+// default if def is true.
+func (s *collectorSwitch) enabled(def bool) bool {
+	if s.disable.value {
+		return false
+	}
+	if s.enable.set {
+		return s.enable.value
+	}
+	return def
+}
+
+// collectorFlags enable and disable the sub-collectors of a role.
+type collectorFlags struct {
+	available []collector.SubCollector
+	switches  map[string]*collectorSwitch
+}
+
+// registerCollectorFlags registers the flags -collector.<name> and
+// -no-collector.<name> for each of the sub-collectors available, unless
+// another role already did for a sub-collector of the same name, with which
+// it then shares them. Sub-collectors not named by the flags keep the
+// default of their role.
+func registerCollectorFlags(available []collector.SubCollector) *collectorFlags {
+	f := &collectorFlags{
+		available: available,
+		switches:  map[string]*collectorSwitch{},
+	}
+	for _, c := range available {
+		s, ok := collectorSwitches[c.Name]
+		if !ok {
+			s = &collectorSwitch{}
+			s.enable.value = c.Default
+			flag.Var(&s.enable, "collector."+c.Name, "Enable the "+c.Name+" collector: "+c.Description)
+			flag.Var(&s.disable, "no-collector."+c.Name, "Disable the "+c.Name+" collector.")
+			collectorSwitches[c.Name] = s
+		}
+		f.switches[c.Name] = s
+	}
+	return f
+}
+
+// get returns the names of the enabled sub-collectors.
+func (f *collectorFlags) get() []string {
+	names := []string{}
+	for _, c := range f.available {
+		if f.switches[c.Name].enabled(c.Default) {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
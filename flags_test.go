@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/wyukawa/hadoop_exporter/collector"
+)
+
+func TestCollectorFlags(t *testing.T) {
+	for _, name := range []string{"collector.jvm", "no-collector.jvm", "collector.apps", "no-collector.apps"} {
+		if flag.Lookup(name) == nil {
+			t.Errorf("flag -%s not registered", name)
+		}
+	}
+	// Collectors with a metric per node or application are opt-in.
+	for _, name := range []string{"collector.datanode_details", "collector.nodes", "collector.apps"} {
+		if f := flag.Lookup(name); f.DefValue != "false" {
+			t.Errorf("-%s defaults to %s", f.Name, f.DefValue)
+		}
+	}
+}
+
+// TestSharedCollectorFlags registers the flags of a sub-collector that two
+// roles have, with different defaults.
+func TestSharedCollectorFlags(t *testing.T) {
+	a := registerCollectorFlags([]collector.SubCollector{{Name: "test_shared", Default: true}, {Name: "test_a", Default: true}})
+	b := registerCollectorFlags([]collector.SubCollector{{Name: "test_shared", Default: false}})
+	if got := a.get(); !reflect.DeepEqual(got, []string{"test_shared", "test_a"}) {
+		t.Errorf("role a enables %v by default", got)
+	}
+	if got := b.get(); len(got) != 0 {
+		t.Errorf("role b enables %v by default", got)
+	}
+	flag.Set("collector.test_shared", "true")
+	if got := b.get(); !reflect.DeepEqual(got, []string{"test_shared"}) {
+		t.Errorf("role b enables %v with -collector.test_shared", got)
+	}
+	flag.Set("no-collector.test_shared", "true")
+	if got := a.get(); !reflect.DeepEqual(got, []string{"test_a"}) {
+		t.Errorf("role a enables %v with -no-collector.test_shared", got)
+	}
+}
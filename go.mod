@@ -1,15 +1,22 @@
 module github.com/wyukawa/hadoop_exporter
 
+go 1.27.1
+
+require (
+	github.com/prometheus/client_golang v0.8.0
+	github.com/prometheus/log v0.0.0-20151026012452-9a3136781e1f
+	gopkg.in/yaml.v2 v2.4.0
+)
+
 require (
 	github.com/Sirupsen/logrus v1.0.6 // indirect
 	github.com/beorn7/perks v0.0.0-20180321164747-3a771d992973 // indirect
 	github.com/golang/protobuf v1.2.0 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
-	github.com/prometheus/client_golang v0.8.0
 	github.com/prometheus/client_model v0.0.0-20180712105110-5c3871d89910 // indirect
 	github.com/prometheus/common v0.0.0-20180801064454-c7de2306084e // indirect
-	github.com/prometheus/log v0.0.0-20151026012452-9a3136781e1f
 	github.com/prometheus/procfs v0.0.0-20180725123919-05ee40e3a273 // indirect
 	golang.org/x/crypto v0.0.0-20180910181607-0e37d006457b // indirect
 	golang.org/x/sys v0.0.0-20180909124046-d0be0721c37e // indirect
+	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
 )
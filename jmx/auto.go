@@ -0,0 +1,138 @@
+package jmx
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Auto exports the numeric attributes that no rule matched the way
+// jmx_exporter does without rules: the bean
+// "Hadoop:service=NameNode,name=FSNamesystem" makes the attribute
+// MissingBlocks the metric Hadoop_NameNode_MissingBlocks{name="FSNamesystem"}.
+// The domain, the value of the first key property and the attribute name
+// form the metric name, the other key properties become labels, and
+// attributes of composite values are named by their path, e.g.
+// java_lang_Memory_HeapMemoryUsage_used.
+//
+// The lists hold regular expressions that must match the whole bean or
+// attribute name. A bean or attribute is exported if it matches an entry of
+// the allow list, or the allow list is empty, and matches no entry of the
+// deny list.
+type Auto struct {
+	AllowBeans      []string `json:"allow_beans,omitempty" yaml:"allow_beans"`
+	DenyBeans       []string `json:"deny_beans,omitempty" yaml:"deny_beans"`
+	AllowAttributes []string `json:"allow_attributes,omitempty" yaml:"allow_attributes"`
+	DenyAttributes  []string `json:"deny_attributes,omitempty" yaml:"deny_attributes"`
+}
+
+type compiledAuto struct {
+	allowBeans, denyBeans           []*regexp.Regexp
+	allowAttributes, denyAttributes []*regexp.Regexp
+}
+
+func compileAuto(a Auto) (*compiledAuto, error) {
+	c := &compiledAuto{}
+	for _, l := range []struct {
+		patterns []string
+		compiled *[]*regexp.Regexp
+		name     string
+	}{
+		{a.AllowBeans, &c.allowBeans, "allowed bean"},
+		{a.DenyBeans, &c.denyBeans, "denied bean"},
+		{a.AllowAttributes, &c.allowAttributes, "allowed attribute"},
+		{a.DenyAttributes, &c.denyAttributes, "denied attribute"},
+	} {
+		for _, p := range l.patterns {
+			re, err := compileAnchored(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s pattern: %s", l.name, err)
+			}
+			*l.compiled = append(*l.compiled, re)
+		}
+	}
+	return c, nil
+}
+
+func (a *compiledAuto) wantsBean(name string) bool {
+	return filter(a.allowBeans, a.denyBeans, name)
+}
+
+func (a *compiledAuto) wantsAttribute(name string) bool {
+	return filter(a.allowAttributes, a.denyAttributes, name)
+}
+
+func filter(allow, deny []*regexp.Regexp, name string) bool {
+	allowed := len(allow) == 0
+	for _, re := range allow {
+		if re.MatchString(name) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return false
+	}
+	for _, re := range deny {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// add sends the metric for attribute of bean if it is a number.
+func (a *compiledAuto) add(s *metricSet, namespace string, bean Bean, attribute string, value interface{}) {
+	v, ok := value.(float64)
+	if !ok || !a.wantsAttribute(attribute) {
+		return
+	}
+	parts := []string{bean.Domain}
+	if keys := objectNameKeys(bean.Name); len(keys) > 0 {
+		parts = append(parts, bean.Properties[keys[0]])
+	}
+	labelNames, labelValues := beanLabels(bean)
+	parts = append(parts, attribute)
+	name := prometheus.BuildFQName(namespace, "", sanitizeName(strings.Join(parts, "_")))
+	help := fmt.Sprintf("Attribute %s of the %s beans.", attribute, strings.Join(parts[:len(parts)-1], " "))
+	s.add(name, help, prometheus.UntypedValue, v, labelNames, labelValues)
+}
+
+// beanLabels returns the key properties of the bean name except the first
+// one, e.g. the service of Hadoop beans or the type of java.lang beans, as
+// labels sorted by name.
+func beanLabels(bean Bean) (names, values []string) {
+	byName := map[string]string{}
+	for i, key := range objectNameKeys(bean.Name) {
+		if i == 0 {
+			continue
+		}
+		l := sanitizeName(key)
+		names = append(names, l)
+		byName[l] = bean.Properties[key]
+	}
+	sort.Strings(names)
+	for _, l := range names {
+		values = append(values, byName[l])
+	}
+	return names, values
+}
+
+// objectNameKeys returns the keys of the key properties of an object name
+// in the order they appear in.
+func objectNameKeys(name string) []string {
+	i := strings.Index(name, ":")
+	if i < 0 {
+		return nil
+	}
+	var keys []string
+	for _, kv := range strings.Split(name[i+1:], ",") {
+		if j := strings.Index(kv, "="); j >= 0 {
+			keys = append(keys, kv[:j])
+		}
+	}
+	return keys
+}
@@ -0,0 +1,58 @@
+// Package jmx turns the beans served by the Hadoop JMX JSON servlet (/jmx)
+// into Prometheus metrics.
+package jmx
+
+import (
+	"strings"
+)
+
+// Bean is a single entry of the "beans" array returned by /jmx.
+type Bean struct {
+	// Name is the full object name, e.g.
+	// "Hadoop:service=NameNode,name=FSNamesystem".
+	Name string
+	// Domain is the part of the object name before the colon, e.g. "Hadoop".
+	Domain string
+	// Properties holds the key properties of the object name, e.g.
+	// {"service": "NameNode", "name": "FSNamesystem"}.
+	Properties map[string]string
+	// Attributes holds every other field of the bean as decoded by
+	// encoding/json.
+	Attributes map[string]interface{}
+}
+
+// ParseObjectName splits a JMX object name such as
+// "java.lang:type=GarbageCollector,name=ParNew" into its domain and key
+// properties.
+func ParseObjectName(name string) (string, map[string]string) {
+	properties := map[string]string{}
+	i := strings.Index(name, ":")
+	if i < 0 {
+		return name, properties
+	}
+	for _, kv := range strings.Split(name[i+1:], ",") {
+		j := strings.Index(kv, "=")
+		if j < 0 {
+			continue
+		}
+		properties[kv[:j]] = kv[j+1:]
+	}
+	return name[:i], properties
+}
+
+// walkAttributes calls fn for every attribute of the bean, descending into
+// composite values such as HeapMemoryUsage. Nested attribute names are joined
+// with a dot, e.g. "HeapMemoryUsage.used".
+func walkAttributes(prefix string, attributes map[string]interface{}, fn func(name string, value interface{})) {
+	for k, v := range attributes {
+		name := k
+		if prefix != "" {
+			name = prefix + "." + k
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			walkAttributes(name, m, fn)
+			continue
+		}
+		fn(name, v)
+	}
+}
@@ -0,0 +1,156 @@
+package jmx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Decode reads a /jmx response from r one bean at a time. Beans for which
+// want returns false are skipped without being kept in memory; a nil want
+// keeps every bean.
+//
+// The servlet writes the name of a bean first, so a skipped bean is usually
+// recognised before any of its attributes are decoded.
+func Decode(r io.Reader, want func(name string) bool) ([]Bean, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	var beans []Bean
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if key != "beans" {
+			if err := skipValue(dec); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := expectDelim(dec, '['); err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			bean, ok, err := decodeBean(dec, want)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				beans = append(beans, bean)
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, err
+		}
+	}
+	return beans, expectDelim(dec, '}')
+}
+
+// decodeBean decodes the next bean object. ok is false if the bean was
+// skipped.
+func decodeBean(dec *json.Decoder, want func(name string) bool) (bean Bean, ok bool, err error) {
+	t, err := dec.Token()
+	if err != nil {
+		return Bean{}, false, err
+	}
+	if t != json.Delim('{') {
+		// Not a bean, ignore it.
+		return Bean{}, false, skipFrom(dec, t)
+	}
+	var name string
+	skip := false
+	attributes := map[string]interface{}{}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return Bean{}, false, err
+		}
+		if skip {
+			if err := skipValue(dec); err != nil {
+				return Bean{}, false, err
+			}
+			continue
+		}
+		if key == "name" {
+			if err := dec.Decode(&name); err != nil {
+				return Bean{}, false, err
+			}
+			if want != nil && !want(name) {
+				skip = true
+				attributes = nil
+			}
+			continue
+		}
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return Bean{}, false, err
+		}
+		attributes[key.(string)] = v
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return Bean{}, false, err
+	}
+	if skip || want != nil && !want(name) {
+		return Bean{}, false, nil
+	}
+	domain, properties := ParseObjectName(name)
+	return Bean{
+		Name:       name,
+		Domain:     domain,
+		Properties: properties,
+		Attributes: attributes,
+	}, true, nil
+}
+
+// skipValue consumes the next value, however deeply nested, without
+// building it.
+func skipValue(dec *json.Decoder) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	return skipFrom(dec, t)
+}
+
+// skipFrom consumes the rest of the value starting with token t.
+func skipFrom(dec *json.Decoder, t json.Token) error {
+	depth := 0
+	for {
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+		var err error
+		if t, err = dec.Token(); err != nil {
+			return err
+		}
+	}
+}
+
+// FormatError is returned by Decode if the response is valid JSON but not
+// shaped like a /jmx response.
+type FormatError struct {
+	msg string
+}
+
+func (e *FormatError) Error() string {
+	return e.msg
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return &FormatError{fmt.Sprintf("expected %q, got %v", delim, t)}
+	}
+	return nil
+}
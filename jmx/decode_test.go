@@ -0,0 +1,65 @@
+package jmx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	beans, err := Decode(strings.NewReader(`{"beans":[
+		{"name":"Hadoop:service=NameNode,name=FSNamesystem","CapacityTotal":100,"tag.HAState":"active"},
+		{"CallQueueLength":3,"name":"Hadoop:service=NameNode,name=RpcActivityForPort8020"},
+		{"name":"java.lang:type=Memory","HeapMemoryUsage":{"used":1}},
+		"not a bean"
+	]}`), func(name string) bool { return !strings.HasPrefix(name, "java.lang:") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Bean{
+		{
+			Name:       "Hadoop:service=NameNode,name=FSNamesystem",
+			Domain:     "Hadoop",
+			Properties: map[string]string{"service": "NameNode", "name": "FSNamesystem"},
+			Attributes: map[string]interface{}{"CapacityTotal": 100.0, "tag.HAState": "active"},
+		},
+		// The name is not the first key.
+		{
+			Name:       "Hadoop:service=NameNode,name=RpcActivityForPort8020",
+			Domain:     "Hadoop",
+			Properties: map[string]string{"service": "NameNode", "name": "RpcActivityForPort8020"},
+			Attributes: map[string]interface{}{"CallQueueLength": 3.0},
+		},
+	}
+	if !reflect.DeepEqual(beans, want) {
+		t.Errorf("got beans %+v, want %+v", beans, want)
+	}
+
+	// A bean whose name comes last is still filtered.
+	beans, err = Decode(strings.NewReader(`{"beans":[{"HeapMemoryUsage":{"used":1},"name":"java.lang:type=Memory"}]}`),
+		func(name string) bool { return name != "java.lang:type=Memory" })
+	if err != nil || len(beans) != 0 {
+		t.Errorf("got beans %+v and error %v, want none", beans, err)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, tc := range []struct {
+		input  string
+		format bool
+	}{
+		{`[{"name":"a:type=A"}]`, true},
+		{`{"beans":{"name":"a:type=A"}}`, true},
+		{`{"beans":[{"name":"a:type=A"}]`, false},
+		{`<html>`, false},
+	} {
+		_, err := Decode(strings.NewReader(tc.input), nil)
+		if err == nil {
+			t.Errorf("%s: no error", tc.input)
+			continue
+		}
+		if _, ok := err.(*FormatError); ok != tc.format {
+			t.Errorf("%s: got error %T %v, FormatError %v wanted", tc.input, err, err, tc.format)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package jmx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/prometheus/log"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+// Fetcher fetches beans from the JMX JSON servlet of a Hadoop daemon.
+type Fetcher struct {
+	Client *http.Client
+	// URL is the URL of the servlet, e.g. "http://localhost:50070/jmx".
+	URL string
+	// Queries, if not empty, are the object name patterns of the beans to
+	// request. There is one /jmx?qry= request per pattern, issued in
+	// parallel. If the servlet rejects a pattern as malformed, all beans
+	// are fetched instead, from then on.
+	Queries []string
+	// Want, if not nil, selects the beans to keep from the responses, see
+	// Decode.
+	Want func(name string) bool
+	// MaxResponseSize, if positive, is the largest response in bytes that
+	// is read.
+	MaxResponseSize int64
+
+	mtx sync.Mutex
+	// unsupported are the queries the servlet rejected.
+	unsupported map[string]bool
+}
+
+// Fetch returns the beans served by the daemon.
+func (f *Fetcher) Fetch(ctx context.Context) ([]Bean, error) {
+	if len(f.Queries) == 0 || f.queriesUnsupported() {
+		return f.fetch(ctx, f.URL)
+	}
+	results := make([][]Bean, len(f.Queries))
+	errs := make([]error, len(f.Queries))
+	var wg sync.WaitGroup
+	for i, query := range f.Queries {
+		u, err := withQuery(f.URL, query)
+		if err != nil {
+			return nil, &scrape.Error{Phase: scrape.PhaseConnect, Err: err}
+		}
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			results[i], errs[i] = f.fetch(ctx, u)
+		}(i, u)
+	}
+	wg.Wait()
+
+	var beans []Bean
+	seen := map[string]bool{}
+	for i, err := range errs {
+		if err != nil {
+			// The servlet answers 400 Bad Request to patterns it cannot
+			// parse. Other failures, e.g. a busy daemon answering 503, are
+			// not worth fetching all beans for.
+			var se *scrape.StatusError
+			if errors.As(err, &se) && se.StatusCode == http.StatusBadRequest {
+				log.Warnf("Query %q not supported, fetching all beans from now on: %s", f.Queries[i], err)
+				f.setUnsupported(f.Queries[i])
+				return f.fetch(ctx, f.URL)
+			}
+			return nil, err
+		}
+		// Patterns may overlap, keep the first copy of each bean.
+		for _, b := range results[i] {
+			if !seen[b.Name] {
+				seen[b.Name] = true
+				beans = append(beans, b)
+			}
+		}
+	}
+	return beans, nil
+}
+
+// queriesUnsupported reports whether the servlet rejected any of the
+// queries before.
+func (f *Fetcher) queriesUnsupported() bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return len(f.unsupported) > 0
+}
+
+func (f *Fetcher) setUnsupported(query string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.unsupported == nil {
+		f.unsupported = map[string]bool{}
+	}
+	f.unsupported[query] = true
+}
+
+// withQuery adds the qry parameter to jmxURL.
+func withQuery(jmxURL, query string) (string, error) {
+	u, err := url.Parse(jmxURL)
+	if err != nil {
+		return "", err
+	}
+	values := u.Query()
+	values.Set("qry", query)
+	u.RawQuery = values.Encode()
+	return u.String(), nil
+}
+
+// fetch returns the beans of a single /jmx response.
+func (f *Fetcher) fetch(ctx context.Context, u string) ([]Bean, error) {
+	body, err := scrape.Get(ctx, f.Client, u, f.MaxResponseSize)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	beans, err := Decode(body, f.Want)
+	if err != nil {
+		return nil, decodeError(err)
+	}
+	return beans, nil
+}
+
+// decodeError tells malformed responses from failures to read them.
+func decodeError(err error) error {
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError, *FormatError:
+		return &scrape.Error{Phase: scrape.PhaseDecode, Err: err}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &scrape.Error{Phase: scrape.PhaseDecode, Err: err}
+	}
+	return scrape.ReadError(err)
+}
@@ -0,0 +1,79 @@
+package jmx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+// newServlet serves two beans, answering requests for the query bad with
+// status and counting the requests by query.
+func newServlet(status int) (*httptest.Server, func() map[string]int) {
+	var mtx sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qry := r.URL.Query().Get("qry")
+		mtx.Lock()
+		requests[qry]++
+		mtx.Unlock()
+		switch qry {
+		case "bad":
+			http.Error(w, "error", status)
+		case "a:type=A":
+			w.Write([]byte(`{"beans":[{"name":"a:type=A","X":1}]}`))
+		default:
+			w.Write([]byte(`{"beans":[{"name":"a:type=A","X":1},{"name":"b:type=B","Y":2}]}`))
+		}
+	}))
+	return server, func() map[string]int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		copy := map[string]int{}
+		for k, v := range requests {
+			copy[k] = v
+		}
+		return copy
+	}
+}
+
+// TestFetchMalformedQuery falls back to fetching all beans once the servlet
+// rejected a pattern, and does not try the patterns again.
+func TestFetchMalformedQuery(t *testing.T) {
+	server, requests := newServlet(http.StatusBadRequest)
+	defer server.Close()
+
+	f := &Fetcher{Client: http.DefaultClient, URL: server.URL + "/jmx", Queries: []string{"a:type=A", "bad"}}
+	for i := 0; i < 2; i++ {
+		beans, err := f.Fetch(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(beans) != 2 {
+			t.Errorf("fetch %d: got %d beans, want all 2", i+1, len(beans))
+		}
+	}
+	if r := requests(); r["bad"] != 1 || r["a:type=A"] != 1 || r[""] != 2 {
+		t.Errorf("unexpected requests by query %v", r)
+	}
+}
+
+// TestFetchServerError fails on a server error without fetching all beans.
+func TestFetchServerError(t *testing.T) {
+	server, requests := newServlet(http.StatusServiceUnavailable)
+	defer server.Close()
+
+	f := &Fetcher{Client: http.DefaultClient, URL: server.URL + "/jmx", Queries: []string{"a:type=A", "bad"}}
+	_, err := f.Fetch(context.Background())
+	var e *scrape.Error
+	if !errors.As(err, &e) || e.Phase != scrape.PhaseStatus {
+		t.Fatalf("got error %v, want one in phase %s", err, scrape.PhaseStatus)
+	}
+	if r := requests(); r[""] != 0 {
+		t.Errorf("all beans fetched after a server error: %v", r)
+	}
+}
@@ -0,0 +1,465 @@
+package jmx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+)
+
+// Rule maps bean attributes to a metric.
+//
+// Bean and Attribute are regular expressions that must match the whole bean
+// name and the whole attribute name. Attributes of composite values are
+// matched with their dotted path, e.g. "HeapMemoryUsage.used". An Attribute
+// without regular expression operators names a single attribute that every
+// matching bean is expected to have.
+//
+// Name, Help and the values of Labels are templates: $1, $2, ... expand to
+// the groups captured by Attribute, ${attribute} and ${domain} to the
+// attribute name and the bean domain, and any other ${key} to the value of
+// that key property of the bean name, e.g. ${name} or ${service}.
+//
+// Query is the JMX object name pattern, e.g. "java.lang:type=Memory" or
+// "Hadoop:service=NameNode,name=*", that selects the beans the rule applies
+// to when fetching them from /jmx. It defaults to Bean if that is not a
+// pattern. Without a query the whole /jmx document is fetched.
+type Rule struct {
+	Bean      string            `json:"bean"`
+	Query     string            `json:"query,omitempty"`
+	Attribute string            `json:"attribute"`
+	Name      string            `json:"name"`
+	Help      string            `json:"help,omitempty"`
+	Type      string            `json:"type,omitempty"` // "gauge" (default), "counter" or "untyped"
+	Labels    map[string]string `json:"labels,omitempty"`
+	// Values maps string attributes to numbers, e.g. {"active": 1}. String
+	// attributes are only exported by rules that set Values, and strings
+	// that are not listed are exported as 0.
+	Values map[string]float64 `json:"values,omitempty"`
+	// Scale, if not 0, multiplies the value, e.g. 0.001 to convert
+	// milliseconds to seconds.
+	Scale float64 `json:"scale,omitempty"`
+}
+
+// LoadRules reads a JSON array of rules from path.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err)
+	}
+	return rules, nil
+}
+
+type compiledRule struct {
+	Rule
+	bean       *regexp.Regexp
+	attribute  *regexp.Regexp
+	valueType  prometheus.ValueType
+	labelNames []string
+	// expected is the attribute name if Attribute is not a pattern.
+	expected string
+}
+
+// MissingAttribute is an attribute that a rule expected by name but that was
+// absent from a matching bean or could not be converted to a number.
+type MissingAttribute struct {
+	Bean      string
+	Attribute string
+}
+
+// Mapper applies a list of rules to beans. Rates and quantiles published by
+// Hadoop metrics2 are turned into summaries before the rules are applied,
+// see addSummaries. The first rule that matches an attribute wins.
+// Attributes that no rule matches are exported automatically if EnableAuto
+// was called.
+type Mapper struct {
+	namespace   string
+	constLabels prometheus.Labels
+	rules       []compiledRule
+	compat      []compiledRule
+	auto        *compiledAuto
+	tagLabels   []string
+}
+
+// NewMapper compiles rules into a Mapper whose metric names are prefixed
+// with namespace and whose metrics carry constLabels.
+func NewMapper(namespace string, constLabels prometheus.Labels, rules []Rule) (*Mapper, error) {
+	m := &Mapper{namespace: namespace, constLabels: constLabels}
+	var err error
+	if m.rules, err = compileRules(rules); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AddCompatRules adds rules that are applied independently of the others:
+// every attribute, except those turned into summaries, is also exported by
+// the first compat rule matching it, whether or not a rule of NewMapper or
+// the automatic export exported it, e.g. tag.HAState as isActive. They keep
+// metrics available under the names of earlier versions.
+func (m *Mapper) AddCompatRules(rules []Rule) error {
+	compat, err := compileRules(rules)
+	if err != nil {
+		return err
+	}
+	m.compat = append(m.compat, compat...)
+	return nil
+}
+
+func compileRules(rules []Rule) ([]compiledRule, error) {
+	var compiled []compiledRule
+	for i, r := range rules {
+		c := compiledRule{Rule: r}
+		var err error
+		if c.bean, err = compileAnchored(r.Bean); err != nil {
+			return nil, fmt.Errorf("rule %d: invalid bean pattern: %s", i, err)
+		}
+		if c.attribute, err = compileAnchored(r.Attribute); err != nil {
+			return nil, fmt.Errorf("rule %d: invalid attribute pattern: %s", i, err)
+		}
+		if isLiteral(r.Attribute) {
+			c.expected = r.Attribute
+		}
+		if c.Query == "" && isLiteral(r.Bean) {
+			c.Query = r.Bean
+		}
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		switch r.Type {
+		case "", "gauge":
+			c.valueType = prometheus.GaugeValue
+		case "counter":
+			c.valueType = prometheus.CounterValue
+		case "untyped":
+			c.valueType = prometheus.UntypedValue
+		default:
+			return nil, fmt.Errorf("rule %d: unknown type %q", i, r.Type)
+		}
+		if c.Scale == 0 {
+			c.Scale = 1
+		}
+		for name := range r.Labels {
+			c.labelNames = append(c.labelNames, name)
+		}
+		sort.Strings(c.labelNames)
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// EnableAuto makes the mapper export the attributes that no rule matches,
+// as selected by a.
+func (m *Mapper) EnableAuto(a Auto) error {
+	c, err := compileAuto(a)
+	if err != nil {
+		return err
+	}
+	m.auto = c
+	return nil
+}
+
+// SetTagLabels makes the mapper add the tags of a bean, e.g. "Hostname" for
+// the attribute tag.Hostname, as labels to the metrics of the bean. The
+// labels are named in snake case, e.g. hostname or ha_state, and are left
+// out for beans without the tag, such as the java.lang beans.
+func (m *Mapper) SetTagLabels(tags []string) {
+	m.tagLabels = tags
+}
+
+// beanTags returns the tag labels of bean.
+func (m *Mapper) beanTags(bean Bean) (names, values []string) {
+	for _, tag := range m.tagLabels {
+		v, ok := bean.Attributes["tag."+tag].(string)
+		if !ok {
+			continue
+		}
+		names = append(names, sanitizeName(snakeCase(tag)))
+		values = append(values, v)
+	}
+	return names, values
+}
+
+// isLiteral reports whether pattern has no regular expression operators
+// other than the dot, so that it is likely meant to match a single name.
+func isLiteral(pattern string) bool {
+	return pattern != "" && !strings.ContainsAny(pattern, `\^$*+?()[]{}|`)
+}
+
+func compileAnchored(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = ".*"
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+// Queries returns the /jmx?qry= patterns that select all beans the rules
+// apply to, or nil if some rule has no query or attributes are exported
+// automatically, and the whole /jmx document is needed.
+func (m *Mapper) Queries() []string {
+	if m.auto != nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var queries []string
+	for _, rules := range [][]compiledRule{m.rules, m.compat} {
+		for _, r := range rules {
+			if r.Query == "" {
+				return nil
+			}
+			if !seen[r.Query] {
+				seen[r.Query] = true
+				queries = append(queries, r.Query)
+			}
+		}
+	}
+	sort.Strings(queries)
+	return queries
+}
+
+// Wants reports whether some rule applies to the bean called name or it is
+// exported automatically.
+func (m *Mapper) Wants(name string) bool {
+	if m.auto != nil && m.auto.wantsBean(name) {
+		return true
+	}
+	for _, rules := range [][]compiledRule{m.rules, m.compat} {
+		for _, r := range rules {
+			if r.bean.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Collect sends the metrics produced by applying the rules to beans and
+// returns the attributes that were expected but missing.
+func (m *Mapper) Collect(beans []Bean, ch chan<- prometheus.Metric) []MissingAttribute {
+	var missing []MissingAttribute
+	s := newMetricSet(ch, m.constLabels)
+	for _, bean := range beans {
+		matching := matchingRules(m.rules, bean)
+		compat := matchingRules(m.compat, bean)
+		auto := m.auto != nil && m.auto.wantsBean(bean.Name)
+		if len(matching) == 0 && len(compat) == 0 && !auto {
+			continue
+		}
+		bs := s.withLabels(m.beanTags(bean))
+		exported := addSummaries(bs, m.namespace, bean)
+		walkAttributes("", bean.Attributes, func(attribute string, value interface{}) {
+			if exported[attribute] {
+				return
+			}
+			m.apply(bs, compat, bean, attribute, value)
+			if m.apply(bs, matching, bean, attribute, value) {
+				exported[attribute] = true
+				return
+			}
+			if auto {
+				m.auto.add(bs, m.namespace, bean, attribute, value)
+			}
+		})
+		for _, r := range matching {
+			if r.expected != "" && !exported[r.expected] {
+				missing = append(missing, MissingAttribute{Bean: bean.Name, Attribute: r.expected})
+			}
+		}
+	}
+	return missing
+}
+
+func matchingRules(rules []compiledRule, bean Bean) []*compiledRule {
+	var matching []*compiledRule
+	for i := range rules {
+		if rules[i].bean.MatchString(bean.Name) {
+			matching = append(matching, &rules[i])
+		}
+	}
+	return matching
+}
+
+// apply exports attribute of bean with the first of rules that matches it
+// and reports whether one did and the value could be converted.
+func (m *Mapper) apply(s *metricSet, rules []*compiledRule, bean Bean, attribute string, value interface{}) bool {
+	for _, r := range rules {
+		groups := r.attribute.FindStringSubmatch(attribute)
+		if groups == nil {
+			continue
+		}
+		v, ok := r.value(value)
+		if !ok {
+			return false
+		}
+		expand := func(template string) string {
+			return os.Expand(template, func(key string) string {
+				if n, err := strconv.Atoi(key); err == nil {
+					if n < len(groups) {
+						return groups[n]
+					}
+					return ""
+				}
+				switch key {
+				case "attribute":
+					return attribute
+				case "domain":
+					return bean.Domain
+				}
+				return bean.Properties[key]
+			})
+		}
+		name := prometheus.BuildFQName(m.namespace, "", sanitizeName(expand(r.Name)))
+		help := expand(r.Help)
+		if help == "" {
+			help = name
+		}
+		labelValues := make([]string, len(r.labelNames))
+		for i, l := range r.labelNames {
+			labelValues[i] = expand(r.Labels[l])
+		}
+		s.add(name, help, r.valueType, v*r.Scale, r.labelNames, labelValues)
+		return true
+	}
+	return false
+}
+
+// value converts a decoded JSON attribute to a sample value.
+func (r *compiledRule) value(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		if r.Values == nil {
+			return 0, false
+		}
+		return r.Values[v], true
+	}
+	return 0, false
+}
+
+// snakeCase converts a camel case attribute name such as CapacityUsedNonDFS
+// to snake case, capacity_used_non_dfs.
+func snakeCase(name string) string {
+	var b []rune
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || unicode.IsUpper(prev) && nextLower {
+				b = append(b, '_')
+			}
+		}
+		b = append(b, unicode.ToLower(r))
+	}
+	return string(b)
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+func sanitizeName(name string) string {
+	return invalidNameChars.ReplaceAllString(name, "_")
+}
+
+// metricSet sends const metrics to a channel, dropping samples that would
+// make the scrape inconsistent: duplicates of an already sent series and
+// series whose type, help or label names differ from earlier ones of the
+// same name.
+type metricSet struct {
+	ch          chan<- prometheus.Metric
+	constLabels prometheus.Labels
+	families    map[string]string
+	seen        map[string]bool
+	// extraNames and extraValues are labels added to every series, see
+	// withLabels.
+	extraNames, extraValues []string
+}
+
+func newMetricSet(ch chan<- prometheus.Metric, constLabels prometheus.Labels) *metricSet {
+	return &metricSet{
+		ch:          ch,
+		constLabels: constLabels,
+		families:    map[string]string{},
+		seen:        map[string]bool{},
+	}
+}
+
+// withLabels returns a view of s that adds the given labels to every series
+// it sends.
+func (s *metricSet) withLabels(names, values []string) *metricSet {
+	c := *s
+	c.extraNames = append(append([]string(nil), s.extraNames...), names...)
+	c.extraValues = append(append([]string(nil), s.extraValues...), values...)
+	return &c
+}
+
+func (s *metricSet) add(name, help string, valueType prometheus.ValueType, value float64, labelNames, labelValues []string) {
+	labelNames, labelValues = s.labels(labelNames, labelValues)
+	desc, ok := s.desc(name, help, fmt.Sprint(valueType), labelNames, labelValues)
+	if !ok {
+		return
+	}
+	m, err := prometheus.NewConstMetric(desc, valueType, value, labelValues...)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	s.ch <- m
+}
+
+func (s *metricSet) addSummary(name, help string, count uint64, sum float64, quantiles map[float64]float64, labelNames, labelValues []string) {
+	labelNames, labelValues = s.labels(labelNames, labelValues)
+	desc, ok := s.desc(name, help, "summary", labelNames, labelValues)
+	if !ok {
+		return
+	}
+	m, err := prometheus.NewConstSummary(desc, count, sum, quantiles, labelValues...)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	s.ch <- m
+}
+
+// labels adds the extra labels to those of a series.
+func (s *metricSet) labels(names, values []string) ([]string, []string) {
+	if len(s.extraNames) == 0 {
+		return names, values
+	}
+	return append(append([]string(nil), names...), s.extraNames...), append(append([]string(nil), values...), s.extraValues...)
+}
+
+// desc returns the descriptor of a new series, or false if the series must
+// be dropped.
+func (s *metricSet) desc(name, help, typ string, labelNames, labelValues []string) (*prometheus.Desc, bool) {
+	family := typ + "\xff" + help + "\xff" + strings.Join(labelNames, "\xff")
+	if prev, ok := s.families[name]; ok && prev != family {
+		log.Debugf("Dropping %s%v: type, help or label names differ from an earlier series", name, labelValues)
+		return nil, false
+	}
+	s.families[name] = family
+	key := name + "\xff" + strings.Join(labelValues, "\xff")
+	if s.seen[key] {
+		log.Debugf("Dropping duplicate series %s%v", name, labelValues)
+		return nil, false
+	}
+	s.seen[key] = true
+	return prometheus.NewDesc(name, help, labelNames, s.constLabels), true
+}
@@ -0,0 +1,186 @@
+package jmx
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// mapperCollector applies a Mapper to fixed beans.
+type mapperCollector struct {
+	mapper *Mapper
+	beans  []Bean
+}
+
+func (c mapperCollector) Describe(ch chan<- *prometheus.Desc) {
+	// The registry needs a descriptor; the metrics of the rules are only
+	// known once the beans are.
+	ch <- prometheus.NewDesc("test_mapper", "Mapper under test.", nil, nil)
+}
+
+func (c mapperCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mapper.Collect(c.beans, ch)
+}
+
+// mapBeans returns the exposition of the metrics mapped from beans, which
+// are given as a /jmx response.
+func mapBeans(t *testing.T, m *Mapper, beans string) string {
+	t.Helper()
+	decoded, err := Decode(strings.NewReader(beans), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(mapperCollector{m, decoded})
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+const ruleBeans = `{"beans":[
+	{"name":"Hadoop:service=NameNode,name=FSNamesystem","tag.HAState":"active","CapacityTotal":1000,"CapacityUsed":10,"BlocksTotal":7},
+	{"name":"Hadoop:service=NameNode,name=RpcActivityForPort8020","RpcQueueTimeAvgTime":1500,"RpcProcessingTimeAvgTime":500}
+]}`
+
+func TestLoadRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jmx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rules.json")
+	if err := ioutil.WriteFile(path, []byte(`[
+		{"bean": "Hadoop:service=NameNode,name=FSNamesystem", "attribute": "Capacity(.*)", "name": "capacity_$1_bytes", "help": "Capacity ${attribute} of the ${name}."},
+		{"bean": "Hadoop:service=NameNode,name=FSNamesystem", "attribute": "BlocksTotal", "name": "blocks", "type": "counter"}
+	]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewMapper("namenode", nil, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q := m.Queries(); len(q) != 1 || q[0] != "Hadoop:service=NameNode,name=FSNamesystem" {
+		t.Errorf("unexpected queries %q", q)
+	}
+	body := mapBeans(t, m, ruleBeans)
+	for _, want := range []string{
+		"# HELP namenode_capacity_Total_bytes Capacity CapacityTotal of the FSNamesystem.",
+		"namenode_capacity_Total_bytes 1000",
+		"namenode_capacity_Used_bytes 10",
+		"# TYPE namenode_blocks counter",
+		"namenode_blocks 7",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "Rpc") {
+		t.Errorf("bean without rules exported:\n%s", body)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"bean": "x"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRules(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("got error %v for a malformed file, want one naming it", err)
+	}
+	if _, err := LoadRules(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("no error for a missing file")
+	}
+}
+
+func TestMapperRules(t *testing.T) {
+	m, err := NewMapper("namenode", prometheus.Labels{"cluster": "a"}, []Rule{
+		// The first matching rule wins, so CapacityTotal is not exported
+		// by the next rule.
+		{Bean: "Hadoop:service=NameNode,name=FSNamesystem", Attribute: "CapacityTotal", Name: "capacity_bytes"},
+		{Bean: "Hadoop:service=NameNode,name=FSNamesystem", Attribute: "Capacity(.*)", Name: "capacity_$1"},
+		{Bean: "Hadoop:service=NameNode,name=FSNamesystem", Attribute: "tag.HAState", Name: "ha_active", Values: map[string]float64{"active": 1}},
+		{
+			Bean:      "Hadoop:service=NameNode,name=RpcActivityForPort(.*)",
+			Attribute: "Rpc(.*)TimeAvgTime",
+			Name:      "rpc_${1}_time_seconds",
+			Labels:    map[string]string{"server": "${name}", "domain": "${domain}"},
+			Scale:     0.001,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := mapBeans(t, m, ruleBeans)
+	for _, want := range []string{
+		`namenode_capacity_bytes{cluster="a"} 1000`,
+		`namenode_capacity_Used{cluster="a"} 10`,
+		`namenode_ha_active{cluster="a"} 1`,
+		`namenode_rpc_Queue_time_seconds{cluster="a",domain="Hadoop",server="RpcActivityForPort8020"} 1.5`,
+		`namenode_rpc_Processing_time_seconds{cluster="a",domain="Hadoop",server="RpcActivityForPort8020"} 0.5`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "namenode_capacity_Total") {
+		t.Errorf("attribute exported by two rules:\n%s", body)
+	}
+
+	m, err = NewMapper("namenode", nil, []Rule{
+		{Bean: "Hadoop:service=NameNode,name=FSNamesystem", Attribute: "tag.HAState", Name: "ha_active", Values: map[string]float64{"active": 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body := mapBeans(t, m, strings.Replace(ruleBeans, `"active"`, `"standby"`, 1)); !strings.Contains(body, "namenode_ha_active 0") {
+		t.Errorf("unlisted string not exported as 0:\n%s", body)
+	}
+}
+
+// TestMapperCompatRules exports attributes by the compat rules as well,
+// whether or not the other rules export them.
+func TestMapperCompatRules(t *testing.T) {
+	m, err := NewMapper("namenode", nil, []Rule{
+		{Bean: "Hadoop:service=NameNode,name=FSNamesystem", Attribute: "CapacityTotal", Name: "capacity_bytes"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddCompatRules([]Rule{
+		{Bean: "Hadoop:service=NameNode,name=FSNamesystem", Attribute: "CapacityTotal", Name: "CapacityTotal"},
+		{Bean: "Hadoop:service=NameNode,name=FSNamesystem", Attribute: "tag.HAState", Name: "isActive", Values: map[string]float64{"active": 1}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	body := mapBeans(t, m, ruleBeans)
+	for _, want := range []string{
+		`namenode_capacity_bytes 1000`,
+		`namenode_CapacityTotal 1000`,
+		// No other rule exports tag.HAState.
+		`namenode_isActive 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestNewMapperErrors(t *testing.T) {
+	for _, r := range []Rule{
+		{Bean: "(", Name: "x"},
+		{Attribute: "[", Name: "x"},
+		{Bean: "a:type=A"},
+		{Bean: "a:type=A", Name: "x", Type: "histogram"},
+	} {
+		if _, err := NewMapper("test", nil, []Rule{r}); err == nil {
+			t.Errorf("rule %+v accepted", r)
+		}
+	}
+}
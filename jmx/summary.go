@@ -0,0 +1,140 @@
+package jmx
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// numOpsAttribute is the count of a Hadoop metrics2 MutableRate or
+	// MutableQuantiles, e.g. RpcQueueTimeNumOps or Syncs60sNumOps.
+	numOpsAttribute = regexp.MustCompile(`^(.+)NumOps$`)
+	// quantileAttribute is a quantile of a MutableQuantiles over a window
+	// in seconds, e.g. Syncs60s90thPercentileLatency.
+	quantileAttribute = regexp.MustCompile(`^(.+?)(\d+)s(\d+)thPercentile(\w+)$`)
+)
+
+// addSummaries turns the Hadoop metrics2 rates and quantiles among the
+// attributes of bean into summaries and returns the attributes it used.
+//
+// A rate is published as a pair such as RpcQueueTimeNumOps and
+// RpcQueueTimeAvgTime and becomes the summary
+// <namespace>_rpc_queue_time_seconds with the count of operations and their
+// total time, derived from the average. As Hadoop averages over the last
+// metrics interval only, that sum is an estimate that can go down and must
+// not be used with rate(). Quantiles are published as
+// Syncs60sNumOps, Syncs60s50thPercentileLatency,
+// Syncs60s90thPercentileLatency, ... and become the summary
+// <namespace>_syncs_latency_seconds{window="60s"} with quantile labels.
+// Hadoop does not publish the sum of the observations of a window, so its
+// sum is NaN. Times and latencies are converted from milliseconds to
+// seconds.
+//
+// Both carry the labels that automatically exported attributes of the bean
+// carry, e.g. name="RpcActivityForPort8020".
+func addSummaries(s *metricSet, namespace string, bean Bean) map[string]bool {
+	used := map[string]bool{}
+	labelNames, labelValues := beanLabels(bean)
+	number := func(attribute string) (float64, bool) {
+		v, ok := bean.Attributes[attribute].(float64)
+		return v, ok
+	}
+
+	type window struct {
+		name, seconds, value string
+	}
+	quantiles := map[window]map[float64]float64{}
+	quantileAttributes := map[window][]string{}
+	for attribute := range bean.Attributes {
+		groups := quantileAttribute.FindStringSubmatch(attribute)
+		if groups == nil {
+			continue
+		}
+		v, ok := number(attribute)
+		if !ok {
+			continue
+		}
+		percentile, _ := strconv.ParseFloat(groups[3], 64)
+		w := window{groups[1], groups[2], groups[4]}
+		if quantiles[w] == nil {
+			quantiles[w] = map[float64]float64{}
+		}
+		quantiles[w][percentile/100] = v
+		quantileAttributes[w] = append(quantileAttributes[w], attribute)
+	}
+	for w, q := range quantiles {
+		countAttribute := w.name + w.seconds + "sNumOps"
+		count, ok := number(countAttribute)
+		if !ok {
+			continue
+		}
+		used[countAttribute] = true
+		for _, attribute := range quantileAttributes[w] {
+			used[attribute] = true
+		}
+		name, scale := summaryName(namespace, w.name, w.value)
+		scaled := map[float64]float64{}
+		for quantile, v := range q {
+			scaled[quantile] = v * scale
+		}
+		help := fmt.Sprintf("Quantiles of the %s of %s operations over a sliding window.", strings.ToLower(w.value), w.name)
+		s.addSummary(name, help, uint64(count), math.NaN(), scaled,
+			append([]string{"window"}, labelNames...),
+			append([]string{w.seconds + "s"}, labelValues...))
+	}
+
+	for attribute := range bean.Attributes {
+		if used[attribute] {
+			continue
+		}
+		groups := numOpsAttribute.FindStringSubmatch(attribute)
+		if groups == nil {
+			continue
+		}
+		count, ok := number(attribute)
+		if !ok {
+			continue
+		}
+		// MutableRate publishes AvgTime, MutableStat Avg<value name>.
+		var averages []string
+		for other := range bean.Attributes {
+			if strings.HasPrefix(other, groups[1]+"Avg") {
+				if _, ok := number(other); ok {
+					averages = append(averages, other)
+				}
+			}
+		}
+		if len(averages) != 1 {
+			continue
+		}
+		avg, _ := number(averages[0])
+		value := strings.TrimPrefix(averages[0], groups[1]+"Avg")
+		used[attribute] = true
+		used[averages[0]] = true
+		name, scale := summaryName(namespace, groups[1], value)
+		help := fmt.Sprintf("Number of %s operations and their total %s, estimated from the average of the last interval. The sum is not monotonic; do not apply rate() to it.", groups[1], strings.ToLower(value))
+		s.addSummary(name, help, uint64(count), avg*count*scale, nil, labelNames, labelValues)
+	}
+	return used
+}
+
+// summaryName returns the name of the summary of the values called value,
+// e.g. Time or Latency, of the operation op and the factor converting them
+// to the unit of the name.
+func summaryName(namespace, op, value string) (string, float64) {
+	name := snakeCase(op)
+	if v := snakeCase(value); !strings.HasSuffix(name, v) {
+		name += "_" + v
+	}
+	scale := 1.0
+	if value == "Time" || value == "Latency" {
+		name += "_seconds"
+		scale = 0.001
+	}
+	return prometheus.BuildFQName(namespace, "", sanitizeName(name)), scale
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/wyukawa/hadoop_exporter/collector"
+	"github.com/wyukawa/hadoop_exporter/config"
+	"github.com/wyukawa/hadoop_exporter/jmx"
+)
+
+var (
+	jmxUrl         = flag.String("jmx.url", "http://localhost:8042/jmx", "JMX JSON servlet URL of the daemon for the jmx role.")
+	jmxNamespace   = flag.String("jmx.namespace", "hadoop", "Prefix of the metric names of the jmx role.")
+	jmxMaxResponse = flag.Int64("jmx.max-response-bytes", 64<<20, "Largest /jmx response to read, in bytes. 0 means no limit.")
+	jmxRules       = flag.String("jmx.rules", "", "Path to a JSON file with JMX bean-to-metric mapping rules for the jmx role.")
+	jmxAuto        = registerAutoFlags("jmx", true)
+	jmxTagLabels   = newStringsFlag("jmx.tag-label", "Tag of the daemon's beans, e.g. Hostname for tag.Hostname, to add as a label to their metrics. May be repeated.")
+)
+
+// jmxConfigFromFlags sets the jmx section of c from the flags.
+func jmxConfigFromFlags(c *config.Config) {
+	c.JMX = &config.JMX{
+		JMXTarget: config.JMXTarget{
+			Target:           config.Target{URL: *jmxUrl},
+			RulesFile:        *jmxRules,
+			Auto:             jmxAuto.get(),
+			TagLabels:        *jmxTagLabels,
+			MaxResponseBytes: jmxMaxResponse,
+		},
+		Namespace: *jmxNamespace,
+	}
+}
+
+// newJMXExporter returns the exporter of the jmx role.
+func newJMXExporter(c *config.Config, client *http.Client) (exporter, error) {
+	j := c.JMX
+	o := collector.JMXOptions{
+		Options: collector.Options{
+			URL:    j.URL,
+			Client: client,
+			Labels: targetLabels(c, j.Target),
+		},
+		Namespace:       j.Namespace,
+		Auto:            j.Auto,
+		TagLabels:       j.TagLabels,
+		MaxResponseSize: j.GetMaxResponseBytes(),
+	}
+	if j.RulesFile != "" {
+		var err error
+		o.Rules, err = jmx.LoadRules(j.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return collector.NewJMX(o)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+	"github.com/wyukawa/hadoop_exporter/config"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+var (
+	constLabels = prometheus.Labels{}
+
+	configFile    = flag.String("config.file", "", "Path to a YAML configuration file describing the roles, see README. Replaces the role flags, and is reloaded on SIGHUP or POST /-/reload.")
+	listenAddress = flag.String("web.listen-address", "", "Address on which to expose metrics and web interface. Defaults to the port of the first role, e.g. :9070 for namenode and :9088 for resourcemanager, or :9070 if no role runs and only /probe is served.")
+	metricsPath   = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics.")
+	rolesFlag     = flag.String("roles", "", "Comma-separated list of roles to run, e.g. namenode,resourcemanager. Roles may also be given as arguments.")
+	legacyNames   = flag.Bool("metrics.legacy-names", false, "Also export the metrics under the names of earlier versions, e.g. namenode_CapacityTotal besides namenode_capacity_bytes.")
+	pollInterval  = flag.Duration("upstream.poll-interval", 0, "Poll the daemons at this interval in the background and serve scrapes from the last successful poll. 0 fetches on every scrape.")
+)
+
+// exporter collects the metrics of one role.
+type exporter interface {
+	scrape.ContextCollector
+	StartPolling(interval time.Duration)
+	StopPolling()
+}
+
+// role is a Hadoop daemon the exporter can serve metrics for.
+type role struct {
+	namespace string
+	title     string
+	address   string
+	// fromFlags sets the section of the role in a configuration from the
+	// command line flags.
+	fromFlags func(c *config.Config)
+	// target returns the daemon of the role in c, or nil if the role is not
+	// configured.
+	target func(c *config.Config) *config.Target
+	// newExporter creates the exporter of the role configured in c.
+	newExporter func(c *config.Config, client *http.Client) (exporter, error)
+}
+
+var roles = map[string]role{
+	"jmx": {"hadoop", "JMX", ":9072", jmxConfigFromFlags, func(c *config.Config) *config.Target {
+		if c.JMX == nil {
+			return nil
+		}
+		return &c.JMX.Target
+	}, newJMXExporter},
+	"namenode": {"namenode", "NameNode", ":9070", nameNodeConfigFromFlags, func(c *config.Config) *config.Target {
+		if c.NameNode == nil {
+			return nil
+		}
+		return &c.NameNode.Target
+	}, newNameNodeExporter},
+	"resourcemanager": {"resourcemanager", "ResourceManager", ":9088", resourceManagerConfigFromFlags, func(c *config.Config) *config.Target {
+		if c.ResourceManager == nil {
+			return nil
+		}
+		return &c.ResourceManager.Target
+	}, newResourceManagerExporter},
+}
+
+// configuredRoles returns the names of the roles configured in c.
+func configuredRoles(c *config.Config) []string {
+	var names []string
+	for _, name := range roleNames() {
+		if roles[name].target(c) != nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// targetLabels returns the labels of the metrics of target: those of the
+// whole configuration and those of the target.
+func targetLabels(c *config.Config, target config.Target) prometheus.Labels {
+	labels := prometheus.Labels{}
+	for name, value := range c.Labels {
+		labels[name] = value
+	}
+	for name, value := range target.Labels {
+		labels[name] = value
+	}
+	return labels
+}
+
+// selectedRoles returns the names of the roles to run: the arguments if any,
+// else the -roles flag, else the role implied by the name the binary was
+// invoked as, e.g. namenode_exporter.
+func selectedRoles() ([]string, error) {
+	var names []string
+	switch {
+	case flag.NArg() > 0:
+		names = flag.Args()
+	case *rolesFlag != "":
+		names = strings.Split(*rolesFlag, ",")
+	default:
+		base := strings.TrimSuffix(filepath.Base(os.Args[0]), "_exporter")
+		if _, ok := roles[base]; !ok {
+			return nil, fmt.Errorf("no role given, choose from %s", strings.Join(roleNames(), ", "))
+		}
+		names = []string{base}
+	}
+	seen := map[string]bool{}
+	var selected []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if _, ok := roles[name]; !ok {
+			return nil, fmt.Errorf("unknown role %q, choose from %s", name, strings.Join(roleNames(), ", "))
+		}
+		if !seen[name] {
+			seen[name] = true
+			selected = append(selected, name)
+		}
+	}
+	return selected, nil
+}
+
+// checkConfigFileFlags returns an error if roles or flags that the
+// configuration file replaces are given along with -config.file.
+func checkConfigFileFlags() error {
+	if flag.NArg() > 0 || *rolesFlag != "" {
+		return errors.New("roles are taken from the configuration file when -config.file is given")
+	}
+	var given []string
+	if len(constLabels) > 0 {
+		given = append(given, "-label")
+	}
+	if *legacyNames {
+		given = append(given, "-metrics.legacy-names")
+	}
+	if len(given) > 0 {
+		return fmt.Errorf("%s cannot be combined with -config.file, set labels and legacy_names in the configuration file instead", strings.Join(given, " and "))
+	}
+	return nil
+}
+
+func roleNames() []string {
+	var names []string
+	for name := range roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [role...]\n\nRoles: %s\n\nFlags:\n", os.Args[0], strings.Join(roleNames(), ", "))
+		flag.PrintDefaults()
+	}
+	flag.Var(labelsFlag(constLabels), "label", "Label of the form name=value added to every metric of the roles, e.g. cluster=prod. May be repeated.")
+	var upstream scrape.Options
+	upstream.RegisterFlags(flag.CommandLine, "daemon")
+	flag.Parse()
+
+	s := newServer(*configFile, upstream)
+	var names []string
+	if *configFile != "" {
+		if err := checkConfigFileFlags(); err != nil {
+			log.Fatal(err)
+		}
+		prometheus.MustRegister(s)
+		if err := s.reload(); err != nil {
+			log.Fatal(err)
+		}
+		c := s.current()
+		names = configuredRoles(c)
+		if len(c.Clusters) > 0 {
+			names = configuredRoles(c.ForCluster(c.Clusters[0]))
+		}
+		if *listenAddress == "" {
+			*listenAddress = s.current().ListenAddress
+		}
+	} else {
+		var err error
+		names, err = selectedRoles()
+		if err != nil {
+			log.Fatal(err)
+		}
+		c := &config.Config{Labels: constLabels, LegacyNames: *legacyNames}
+		for _, name := range names {
+			roles[name].fromFlags(c)
+		}
+		if err := c.Validate(); err != nil {
+			log.Fatal(err)
+		}
+		if err := s.apply(c); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *listenAddress == "" {
+		*listenAddress = roles["namenode"].address
+		if len(names) > 0 {
+			*listenAddress = roles[names[0]].address
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go s.reloadOn(hup)
+
+	log.Printf("Starting Server: %s, roles: %s", *listenAddress, strings.Join(s.currentTitles(), ", "))
+	http.Handle(*metricsPath, scrape.DynamicHandler(s.currentCollectors))
+	http.HandleFunc("/-/reload", s.serveReload)
+	http.HandleFunc("/probe", s.serveProbe)
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		var links string
+		for _, title := range s.currentTitles() {
+			links += "<li>" + title + "</li>\n"
+		}
+		w.Write([]byte(`<html>
+		<head><title>Hadoop Exporter</title></head>
+		<body>
+		<h1>Hadoop Exporter</h1>
+		<ul>
+		` + links + `</ul>
+		<p><a href="` + *metricsPath + `">Metrics</a></p>
+		</body>
+		</html>`))
+	})
+	err := http.ListenAndServe(*listenAddress, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/wyukawa/hadoop_exporter/collector"
+	"github.com/wyukawa/hadoop_exporter/config"
+	"github.com/wyukawa/hadoop_exporter/jmx"
+)
+
+var (
+	namenodeJmxUrl       = flag.String("namenode.jmx.url", "http://localhost:50070/jmx", "Hadoop JMX URL.")
+	namenodeMaxResponse  = flag.Int64("namenode.jmx.max-response-bytes", 64<<20, "Largest /jmx response to read, in bytes. 0 means no limit.")
+	namenodeRules        = flag.String("namenode.rules", "", "Path to a JSON file with JMX bean-to-metric mapping rules. The built-in rules are used if empty.")
+	namenodeAuto         = registerAutoFlags("namenode", false)
+	namenodeCollectors   = registerCollectorFlags(collector.NameNodeCollectors())
+	namenodeTagLabels    = newStringsFlag("namenode.tag-label", "Tag of the NameNode's beans, e.g. Hostname for tag.Hostname, to add as a label to their metrics. May be repeated.")
+	namenodeMaxDataNodes = flag.Int("namenode.datanodes.max", 0, "Largest number of DataNodes exported by the datanode_details collector. 0 means no limit.")
+	namenodeHAIsActive   = flag.Bool("namenode.ha.is-active", false, "Read the HA state of the -namenode.ha.namenode NameNodes from their /isActive servlet instead of the NameNodeStatus bean.")
+	namenodeHA           = newMapFlag("namenode.ha.namenode", "NameNode of an HA nameservice as id=url, e.g. nn1=http://nn1:50070/jmx, to scrape instead of -namenode.jmx.url. May be repeated.")
+)
+
+// nameNodeConfigFromFlags sets the namenode section of c from the flags.
+func nameNodeConfigFromFlags(c *config.Config) {
+	c.NameNode = &config.NameNode{JMXTarget: config.JMXTarget{
+		Target: config.Target{
+			URL:        *namenodeJmxUrl,
+			Collectors: namenodeCollectors.get(),
+		},
+		RulesFile:        *namenodeRules,
+		Auto:             namenodeAuto.get(),
+		TagLabels:        *namenodeTagLabels,
+		MaxResponseBytes: namenodeMaxResponse,
+	}, MaxDataNodes: *namenodeMaxDataNodes}
+	if len(namenodeHA) > 0 {
+		c.NameNode.URL = ""
+		c.NameNode.NameNodes = namenodeHA
+		c.NameNode.IsActiveServlet = *namenodeHAIsActive
+	}
+}
+
+// newNameNodeExporter returns the exporter of the namenode role.
+func newNameNodeExporter(c *config.Config, client *http.Client) (exporter, error) {
+	nn := c.NameNode
+	o := collector.NameNodeOptions{
+		Options: collector.Options{
+			URL:         nn.URL,
+			Client:      client,
+			LegacyNames: c.LegacyNames,
+			Labels:      targetLabels(c, nn.Target),
+			Collectors:  nn.Collectors,
+		},
+		Auto:            nn.Auto,
+		TagLabels:       nn.TagLabels,
+		MaxResponseSize: nn.GetMaxResponseBytes(),
+		MaxDataNodes:    nn.MaxDataNodes,
+	}
+	if nn.RulesFile != "" {
+		var err error
+		o.Rules, err = jmx.LoadRules(nn.RulesFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(nn.Nameservices) > 0 {
+		return collector.NewNameNodeFederation(collector.NameNodeFederationOptions{
+			NameNodeOptions: o,
+			Nameservices:    nn.Nameservices,
+			IsActive:        nn.IsActiveServlet,
+		})
+	}
+	if len(nn.NameNodes) > 0 {
+		return collector.NewNameNodeHA(collector.NameNodeHAOptions{
+			NameNodeOptions: o,
+			NameNodes:       nn.NameNodes,
+			IsActive:        nn.IsActiveServlet,
+		})
+	}
+	return collector.NewNameNode(o)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wyukawa/hadoop_exporter/config"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+// TestNameNodeRulesFlag replaces the built-in collectors with the rules of
+// the file given with -namenode.rules.
+func TestNameNodeRulesFlag(t *testing.T) {
+	namenode := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"beans":[{"name":"Hadoop:service=NameNode,name=FSNamesystem","CapacityTotal":1000,"BlocksTotal":7}]}`))
+	}))
+	defer namenode.Close()
+
+	dir, err := ioutil.TempDir("", "hadoop_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rules := filepath.Join(dir, "rules.json")
+	if err := ioutil.WriteFile(rules, []byte(`[{"bean": "Hadoop:service=NameNode,name=FSNamesystem", "attribute": "CapacityTotal", "name": "custom_capacity_bytes"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{"namenode.rules": rules, "namenode.jmx.url": namenode.URL + "/jmx"} {
+		old := flag.Lookup(name).Value.String()
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+		defer flag.Set(name, old)
+	}
+
+	c := &config.Config{}
+	nameNodeConfigFromFlags(c)
+	s := newServer("", scrape.Options{})
+	if err := s.apply(c); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	scrape.DynamicHandler(s.currentCollectors).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, want := range []string{"namenode_custom_capacity_bytes 1000", `namenode_scrape_collector_success{collector="rules"} 1`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "namenode_blocks") {
+		t.Errorf("built-in collectors ran along with the rules:\n%s", body)
+	}
+}
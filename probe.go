@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/log"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+// serveProbe scrapes the daemon given by the target parameter with the
+// module given by the module parameter, e.g.
+// /probe?target=namenode:50070&module=namenode. The collector is created for
+// the request and discarded afterwards along with its connections.
+func (s *server) serveProbe(w http.ResponseWriter, r *http.Request) {
+	c := s.current()
+	moduleName := r.URL.Query().Get("module")
+	m, ok := c.Module(moduleName)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+	target, err := probeURL(m.Role, r.URL.Query().Get("target"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	probe := c.ForTarget(m, target)
+	ro := roles[m.Role]
+
+	upstream := s.upstream
+	// The breaker of a transport that lives for one request never opens.
+	upstream.BreakerFailures = 0
+	transport, client, err := newClient(ro.namespace, targetLabels(probe, m.Target), m.Target, upstream)
+	if err != nil {
+		log.Errorf("Error probing %s with module %s: %s", target, moduleName, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer transport.CloseIdleConnections()
+	e, err := ro.newExporter(probe, client)
+	if err != nil {
+		log.Errorf("Error probing %s with module %s: %s", target, moduleName, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	scrape.ProbeHandler(transport, e).ServeHTTP(w, r)
+}
+
+// probeURL completes the target of a probe of role to the URL its collector
+// expects: http:// is assumed if the scheme is missing, and /jmx if the
+// path of a JMX servlet is.
+func probeURL(role, target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("Target parameter is missing")
+	}
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("Invalid target %q", target)
+	}
+	if role != "resourcemanager" && (u.Path == "" || u.Path == "/") {
+		u.Path = "/jmx"
+	}
+	return u.String(), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func probeRequest(s *server, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.serveProbe(w, httptest.NewRequest("GET", "/probe?"+query, nil))
+	return w
+}
+
+func TestServeProbe(t *testing.T) {
+	var (
+		mtx   sync.Mutex
+		paths []string
+		open  = map[net.Conn]bool{}
+	)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		paths = append(paths, r.URL.Path)
+		mtx.Unlock()
+		w.Write([]byte(`{"beans":[]}`))
+	}))
+	upstream.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		switch state {
+		case http.StateNew:
+			open[c] = true
+		case http.StateClosed, http.StateHijacked:
+			delete(open, c)
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	s, _ := newTestServer(t, "modules:\n  nn:\n    role: namenode\n    collectors: [jvm]\n")
+	defer os.RemoveAll(filepath.Dir(s.configFile))
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := probeRequest(s, "module=datanode&target="+upstream.Listener.Addr().String()); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Unknown module") {
+		t.Errorf("unknown module answered with %d: %s", w.Code, w.Body)
+	}
+	if w := probeRequest(s, "module=nn"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Target parameter is missing") {
+		t.Errorf("missing target answered with %d: %s", w.Code, w.Body)
+	}
+	mtx.Lock()
+	if len(paths) != 0 {
+		t.Errorf("invalid probes fetched %q", paths)
+	}
+	mtx.Unlock()
+
+	w := probeRequest(s, "module=nn&target="+upstream.Listener.Addr().String())
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "namenode_scrape_collector_success") {
+		t.Errorf("probe answered with %d:\n%s", w.Code, w.Body)
+	}
+	mtx.Lock()
+	if len(paths) == 0 || paths[0] != "/jmx" {
+		t.Errorf("probe fetched %q, want /jmx", paths)
+	}
+	mtx.Unlock()
+
+	// The connections of the probe are closed once it is done.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mtx.Lock()
+		n := len(open)
+		mtx.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections of the probe still open", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
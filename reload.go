@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/log"
+	"github.com/wyukawa/hadoop_exporter/config"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+// server holds the exporters built from the current configuration and
+// replaces them when the configuration file is reloaded. Scrapes keep being
+// served from the old exporters until the new ones are ready.
+type server struct {
+	configFile string
+	upstream   scrape.Options
+
+	// reloadMtx serializes reloads.
+	reloadMtx sync.Mutex
+
+	mtx        sync.RWMutex
+	config     *config.Config
+	exporters  []exporter
+	collectors []scrape.ContextCollector
+	titles     []string
+
+	reloadSuccess     prometheus.Gauge
+	reloadSuccessTime prometheus.Gauge
+	configHash        prometheus.Gauge
+}
+
+func newServer(configFile string, upstream scrape.Options) *server {
+	s := &server{
+		configFile: configFile,
+		upstream:   upstream,
+		reloadSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "hadoop_exporter",
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload succeeded.",
+		}),
+		reloadSuccessTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "hadoop_exporter",
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Time of the last successful configuration reload.",
+		}),
+		configHash: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "hadoop_exporter",
+			Name:      "config_hash",
+			Help:      "Hash of the loaded configuration file.",
+		}),
+	}
+	return s
+}
+
+// Describe implements the prometheus.Collector interface. The metrics of the
+// server describe the configuration file, it is registered only if there is
+// one.
+func (s *server) Describe(ch chan<- *prometheus.Desc) {
+	s.reloadSuccess.Describe(ch)
+	s.reloadSuccessTime.Describe(ch)
+	s.configHash.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (s *server) Collect(ch chan<- prometheus.Metric) {
+	s.reloadSuccess.Collect(ch)
+	s.reloadSuccessTime.Collect(ch)
+	s.configHash.Collect(ch)
+}
+
+// loadConfig reads and validates the configuration file and returns it
+// along with its hash.
+func (s *server) loadConfig() (*config.Config, float64, error) {
+	data, err := ioutil.ReadFile(s.configFile)
+	if err != nil {
+		return nil, 0, err
+	}
+	c, err := config.Parse(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %s", s.configFile, err)
+	}
+	sum := sha256.Sum256(data)
+	// The first 48 bits of the hash, which a float64 holds exactly.
+	return c, float64(binary.BigEndian.Uint64(sum[:8]) >> 16), nil
+}
+
+// apply builds the exporters of the roles configured in c, at the top level
+// and in its clusters, and makes them serve scrapes. The current exporters
+// are kept if that fails.
+func (s *server) apply(c *config.Config) error {
+	var (
+		exporters  []exporter
+		collectors []scrape.ContextCollector
+		titles     []string
+	)
+	units := []struct {
+		cluster string
+		config  *config.Config
+	}{{"", c}}
+	for _, cl := range c.Clusters {
+		units = append(units, struct {
+			cluster string
+			config  *config.Config
+		}{cl.Name, c.ForCluster(cl)})
+	}
+	for _, u := range units {
+		for _, name := range configuredRoles(u.config) {
+			r := roles[name]
+			title := r.title
+			if u.cluster != "" {
+				title += " (" + u.cluster + ")"
+			}
+			target := r.target(u.config)
+			transport, client, err := newClient(r.namespace, targetLabels(u.config, *target), *target, s.upstream)
+			if err != nil {
+				return fmt.Errorf("%s: %s", title, err)
+			}
+			e, err := r.newExporter(u.config, client)
+			if err != nil {
+				return fmt.Errorf("%s: %s", title, err)
+			}
+			exporters = append(exporters, e)
+			collectors = append(collectors, transport, e)
+			titles = append(titles, title)
+		}
+	}
+	for _, e := range exporters {
+		if *pollInterval > 0 {
+			e.StartPolling(*pollInterval)
+		}
+	}
+
+	s.mtx.Lock()
+	old := s.exporters
+	s.config = c
+	s.exporters = exporters
+	s.collectors = collectors
+	s.titles = titles
+	s.mtx.Unlock()
+	for _, e := range old {
+		e.StopPolling()
+	}
+	return nil
+}
+
+// newClient returns a transport for target whose metrics are prefixed with
+// namespace and carry labels, and a client using it with the timeout and
+// credentials of target.
+func newClient(namespace string, labels prometheus.Labels, target config.Target, upstream scrape.Options) (*scrape.Transport, *http.Client, error) {
+	if target.Timeout > 0 {
+		upstream.ReadTimeout = target.Timeout
+	}
+	transport := scrape.NewTransport(namespace, labels, upstream)
+	client, err := authenticate(transport.Client(), target.BasicAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	return transport, client, nil
+}
+
+// authenticate returns client sending the credentials of a, if not nil.
+func authenticate(client *http.Client, a *config.BasicAuth) (*http.Client, error) {
+	if a == nil {
+		return client, nil
+	}
+	password, err := a.GetPassword()
+	if err != nil {
+		return nil, err
+	}
+	return scrape.WithBasicAuth(client, a.Username, password), nil
+}
+
+// reload reads the configuration file again and applies it.
+func (s *server) reload() error {
+	if s.configFile == "" {
+		return errors.New("no configuration file given with -config.file")
+	}
+	s.reloadMtx.Lock()
+	defer s.reloadMtx.Unlock()
+	c, hash, err := s.loadConfig()
+	if err == nil {
+		if cur := s.current(); cur != nil && c.ListenAddress != cur.ListenAddress {
+			log.Warnf("listen_address changed to %q, restart the exporter to apply", c.ListenAddress)
+		}
+		err = s.apply(c)
+	}
+	if err != nil {
+		s.reloadSuccess.Set(0)
+		return err
+	}
+	s.reloadSuccess.Set(1)
+	s.reloadSuccessTime.Set(float64(time.Now().UnixNano()) / 1e9)
+	s.configHash.Set(hash)
+	log.Infof("Reloaded %s", s.configFile)
+	return nil
+}
+
+func (s *server) current() *config.Config {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.config
+}
+
+func (s *server) currentCollectors() []scrape.ContextCollector {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.collectors
+}
+
+func (s *server) currentTitles() []string {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.titles
+}
+
+// reloadOn reloads the configuration whenever a signal is received on
+// signals, until it is closed.
+func (s *server) reloadOn(signals <-chan os.Signal) {
+	for range signals {
+		if err := s.reload(); err != nil {
+			log.Errorf("Error reloading the configuration: %s", err)
+		}
+	}
+}
+
+// serveReload reloads the configuration on POST /-/reload.
+func (s *server) serveReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Only POST requests allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.reload(); err != nil {
+		log.Errorf("Error reloading the configuration: %s", err)
+		http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/wyukawa/hadoop_exporter/scrape"
+)
+
+// newTestServer returns a server reading a configuration file with content
+// and a function replacing the content.
+func newTestServer(t *testing.T, content string) (*server, func(string)) {
+	dir, err := ioutil.TempDir("", "hadoop_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(content)
+	return newServer(path, scrape.Options{ReadTimeout: time.Second}), write
+}
+
+// serverMetrics returns the metrics of s in the text format.
+func serverMetrics(t *testing.T, s *server) string {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(s)
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+func reloadRequest(s *server, method string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.serveReload(w, httptest.NewRequest(method, "/-/reload", nil))
+	return w
+}
+
+// TestCheckConfigFileFlags rejects the flags that -config.file replaces.
+func TestCheckConfigFileFlags(t *testing.T) {
+	if err := checkConfigFileFlags(); err != nil {
+		t.Fatal(err)
+	}
+	constLabels["cluster"] = "a"
+	defer delete(constLabels, "cluster")
+	*legacyNames = true
+	defer func() { *legacyNames = false }()
+	err := checkConfigFileFlags()
+	if err == nil || !strings.Contains(err.Error(), "-label and -metrics.legacy-names") {
+		t.Errorf("got %v, want an error naming -label and -metrics.legacy-names", err)
+	}
+}
+
+func TestServeReload(t *testing.T) {
+	s, write := newTestServer(t, "namenode: {url: http://nn:50070/jmx}\n")
+	defer os.RemoveAll(filepath.Dir(s.configFile))
+
+	if w := reloadRequest(s, "GET"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET answered with %d", w.Code)
+	}
+	if s.current() != nil {
+		t.Fatal("configuration loaded on GET")
+	}
+	if w := reloadRequest(s, "POST"); w.Code != http.StatusOK {
+		t.Fatalf("reload failed with %d: %s", w.Code, w.Body)
+	}
+	if titles := s.currentTitles(); len(titles) != 1 || titles[0] != "NameNode" {
+		t.Errorf("unexpected roles %q", titles)
+	}
+	if m := serverMetrics(t, s); !strings.Contains(m, "hadoop_exporter_config_last_reload_successful 1") {
+		t.Errorf("reload success not reported:\n%s", m)
+	}
+
+	write("namenode: {url: http://nn:50070/jmx}\nresourcemanager: {url: http://rm:8088}\n")
+	if w := reloadRequest(s, "POST"); w.Code != http.StatusOK {
+		t.Fatalf("reload failed with %d: %s", w.Code, w.Body)
+	}
+	if titles := s.currentTitles(); len(titles) != 2 {
+		t.Errorf("new roles not applied: %q", titles)
+	}
+}
+
+// TestReloadInvalid keeps the exporters of the old configuration when the
+// new one is invalid.
+func TestReloadInvalid(t *testing.T) {
+	s, write := newTestServer(t, "namenode: {url: http://nn:50070/jmx}\n")
+	defer os.RemoveAll(filepath.Dir(s.configFile))
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	old, collectors := s.current(), s.currentCollectors()
+
+	write("namenode: {url: http://nn:50070/jmx, unknown: 1}\n")
+	w := reloadRequest(s, "POST")
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "unknown") {
+		t.Errorf("invalid configuration answered with %d: %s", w.Code, w.Body)
+	}
+	if s.current() != old || len(s.currentCollectors()) != len(collectors) || s.currentCollectors()[1] != collectors[1] {
+		t.Error("old exporters replaced")
+	}
+	m := serverMetrics(t, s)
+	if !strings.Contains(m, "hadoop_exporter_config_last_reload_successful 0") {
+		t.Errorf("reload failure not reported:\n%s", m)
+	}
+	if strings.Contains(m, "hadoop_exporter_config_last_reload_success_timestamp_seconds 0") {
+		t.Errorf("time of the successful reload reset:\n%s", m)
+	}
+}
+
+func TestReloadOnSignal(t *testing.T) {
+	s, write := newTestServer(t, "namenode: {url: http://nn:50070/jmx}\n")
+	defer os.RemoveAll(filepath.Dir(s.configFile))
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	hup := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		s.reloadOn(hup)
+		close(done)
+	}()
+	write("resourcemanager: {url: http://rm:8088}\n")
+	hup <- syscall.SIGHUP
+	close(hup)
+	<-done
+	if titles := s.currentTitles(); len(titles) != 1 || titles[0] != "ResourceManager" {
+		t.Errorf("configuration not reloaded on SIGHUP, roles %q", titles)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/wyukawa/hadoop_exporter/collector"
+	"github.com/wyukawa/hadoop_exporter/config"
+)
+
+var (
+	resourceManagerUrl        = flag.String("resourcemanager.url", "http://localhost:8088", "Hadoop ResourceManager URL.")
+	resourceManagerCollectors = registerCollectorFlags(collector.ResourceManagerCollectors())
+)
+
+// resourceManagerConfigFromFlags sets the resourcemanager section of c from
+// the flags.
+func resourceManagerConfigFromFlags(c *config.Config) {
+	c.ResourceManager = &config.ResourceManager{Target: config.Target{
+		URL:        *resourceManagerUrl,
+		Collectors: resourceManagerCollectors.get(),
+	}}
+}
+
+// newResourceManagerExporter returns the exporter of the resourcemanager
+// role.
+func newResourceManagerExporter(c *config.Config, client *http.Client) (exporter, error) {
+	rm := c.ResourceManager
+	return collector.NewResourceManager(collector.Options{
+		URL:         rm.URL,
+		Client:      client,
+		LegacyNames: c.LegacyNames,
+		Labels:      targetLabels(c, rm.Target),
+		Collectors:  rm.Collectors,
+	})
+}
@@ -0,0 +1,54 @@
+package scrape
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Group coalesces concurrent collections from the same daemon, so that
+// simultaneous scrapes share one fetch. The zero value is ready to use.
+type Group struct {
+	mtx   sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done    chan struct{}
+	metrics []prometheus.Metric
+	err     error
+}
+
+// Do runs collect and returns the metrics it sent, unless a run for key,
+// usually the URL of the daemon, is already in flight. In that case Do
+// waits for that run and returns its result with shared set to true. If
+// ctx is done first, it returns ctx.Err() unwrapped, also with shared set,
+// as the scrape gave up rather than the daemon failed and the run records
+// its own outcome.
+func (g *Group) Do(ctx context.Context, key string, collect CollectFunc) (metrics []prometheus.Metric, shared bool, err error) {
+	g.mtx.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call{}
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mtx.Unlock()
+		select {
+		case <-c.done:
+			return c.metrics, true, c.err
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mtx.Unlock()
+
+	c.metrics, c.err = Gather(ctx, collect)
+
+	g.mtx.Lock()
+	delete(g.calls, key)
+	g.mtx.Unlock()
+	close(c.done)
+	return c.metrics, false, c.err
+}
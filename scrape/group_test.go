@@ -0,0 +1,39 @@
+package scrape
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestGroupCancelledWaiter returns the bare context error to a scrape that
+// gives up waiting for a run in flight.
+func TestGroupCancelledWaiter(t *testing.T) {
+	var g Group
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, _, err := g.Do(context.Background(), "nn1", func(ctx context.Context, ch chan<- prometheus.Metric) error {
+			close(started)
+			<-release
+			return nil
+		})
+		done <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, shared, err := g.Do(ctx, "nn1", func(ctx context.Context, ch chan<- prometheus.Metric) error {
+		t.Error("second run started")
+		return nil
+	})
+	if err != context.Canceled || !shared {
+		t.Errorf("got error %v and shared %t, want %v and true", err, shared, context.Canceled)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("run failed: %s", err)
+	}
+}
@@ -0,0 +1,92 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// timeoutOffset is subtracted from the scrape timeout announced by
+// Prometheus so that there is time left to send the response.
+const timeoutOffset = 500 * time.Millisecond
+
+// Context returns a context for serving the scrape request r. It is
+// cancelled when the client goes away and, if Prometheus announced its scrape
+// timeout in the X-Prometheus-Scrape-Timeout-Seconds header, shortly before
+// that timeout.
+func Context(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx := r.Context()
+	if v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); v != "" {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err == nil && seconds > 0 {
+			timeout := time.Duration(seconds * float64(time.Second))
+			if timeout > 2*timeoutOffset {
+				timeout -= timeoutOffset
+			}
+			return context.WithTimeout(ctx, timeout)
+		}
+	}
+	return context.WithCancel(ctx)
+}
+
+// ContextCollector is a prometheus.Collector that can also collect on behalf
+// of a particular scrape, giving up when ctx is done.
+type ContextCollector interface {
+	prometheus.Collector
+	CollectContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+type boundCollector struct {
+	ContextCollector
+	ctx context.Context
+}
+
+func (c boundCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(c.ctx, ch)
+}
+
+// Handler serves the metrics of the default registry together with those of
+// cs. cs are collected with the context of each scrape request, see Context.
+func Handler(cs ...ContextCollector) http.Handler {
+	return DynamicHandler(func() []ContextCollector { return cs })
+}
+
+// DynamicHandler is like Handler but calls collectors on every scrape for
+// the collectors to serve, so that they can be replaced while serving, e.g.
+// when the configuration is reloaded.
+func DynamicHandler(collectors func() []ContextCollector) http.Handler {
+	return prometheus.InstrumentHandler("prometheus", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := Context(r)
+		defer cancel()
+		registry := prometheus.NewRegistry()
+		for _, c := range collectors() {
+			// Collectors whose metrics clash, e.g. with different label
+			// names, fail the scrape instead of the process.
+			if err := registry.Register(boundCollector{c, ctx}); err != nil {
+				http.Error(w, "An error has occurred while registering the collectors:\n\n"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, registry}
+		promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	}))
+}
+
+// ProbeHandler serves the metrics of cs only, collected with the context of
+// the request, see Context. It is meant for collectors created for a single
+// request, like those of blackbox_exporter's /probe.
+func ProbeHandler(cs ...ContextCollector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := Context(r)
+		defer cancel()
+		registry := prometheus.NewRegistry()
+		for _, c := range cs {
+			registry.MustRegister(boundCollector{c, ctx})
+		}
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,110 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestContext derives the deadline of a scrape from the timeout announced
+// by Prometheus, leaving time to send the response if it is long enough.
+func TestContext(t *testing.T) {
+	for _, tc := range []struct {
+		header  string
+		timeout time.Duration // 0 for no deadline
+	}{
+		{"", 0},
+		{"invalid", 0},
+		{"-1", 0},
+		{"10", 10*time.Second - timeoutOffset},
+		{"2.5", 2500*time.Millisecond - timeoutOffset},
+		// Too short to be shortened.
+		{"0.8", 800 * time.Millisecond},
+	} {
+		r := httptest.NewRequest("GET", "/metrics", nil)
+		if tc.header != "" {
+			r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tc.header)
+		}
+		start := time.Now()
+		ctx, cancel := Context(r)
+		deadline, ok := ctx.Deadline()
+		cancel()
+		if tc.timeout == 0 {
+			if ok {
+				t.Errorf("%q: got deadline in %s, want none", tc.header, deadline.Sub(start))
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%q: got no deadline, want one in %s", tc.header, tc.timeout)
+			continue
+		}
+		if d := deadline.Sub(start) - tc.timeout; d < 0 || d > 100*time.Millisecond {
+			t.Errorf("%q: got deadline in %s, want one in %s", tc.header, deadline.Sub(start), tc.timeout)
+		}
+	}
+}
+
+var testUp = prometheus.NewDesc("test_up", "Whether the upstream answered.", nil, nil)
+
+// getCollector fetches url on every scrape and reports the error.
+type getCollector struct {
+	url  string
+	errs chan error
+}
+
+func (c getCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- testUp
+}
+
+func (c getCollector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectContext(context.Background(), ch)
+}
+
+func (c getCollector) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	body, err := Get(ctx, http.DefaultClient, c.url, 0)
+	up := 0.0
+	if err == nil {
+		body.Close()
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(testUp, prometheus.GaugeValue, up)
+	c.errs <- err
+}
+
+// TestHandlerSlowUpstream aborts the request to an upstream that does not
+// answer within the scrape timeout.
+func TestHandlerSlowUpstream(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	c := getCollector{upstream.URL, make(chan error, 1)}
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.2")
+	start := time.Now()
+	w := httptest.NewRecorder()
+	Handler(c).ServeHTTP(w, r)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("scrape took %s", elapsed)
+	}
+	if !strings.Contains(w.Body.String(), "test_up 0") {
+		t.Errorf("got\n%s\nwant test_up 0", w.Body)
+	}
+	err := <-c.errs
+	if e, ok := err.(*Error); !ok || e.Phase != PhaseConnect || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want a connect error from the deadline", err)
+	}
+}
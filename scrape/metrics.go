@@ -0,0 +1,145 @@
+package scrape
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are the exporter's own metrics about fetching from a daemon:
+// <namespace>_up, <namespace>_scrape_duration_seconds,
+// <namespace>_scrape_collector_duration_seconds{collector},
+// <namespace>_scrape_collector_success{collector},
+// <namespace>_scrape_errors_total{phase},
+// <namespace>_scrape_shared_total and
+// <namespace>_exporter_missing_attributes_total{bean,attribute}.
+type Metrics struct {
+	constLabels       prometheus.Labels
+	up                *prometheus.Desc
+	duration          *prometheus.Desc
+	collectorDuration *prometheus.Desc
+	collectorSuccess  *prometheus.Desc
+	errors            *prometheus.CounterVec
+	shared            prometheus.Counter
+	missing           *prometheus.CounterVec
+}
+
+// NewMetrics returns the scrape metrics for the given namespace. All of them
+// carry constLabels.
+func NewMetrics(namespace string, constLabels prometheus.Labels) *Metrics {
+	m := &Metrics{
+		constLabels: constLabels,
+		up: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "up"),
+			"Whether the last scrape of the daemon succeeded.",
+			nil, constLabels,
+		),
+		duration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "scrape", "duration_seconds"),
+			"Duration of the last scrape of the daemon.",
+			nil, constLabels,
+		),
+		collectorDuration: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
+			"Duration of the last run of a collector.",
+			[]string{"collector"}, constLabels,
+		),
+		collectorSuccess: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "scrape", "collector_success"),
+			"Whether the last run of a collector succeeded.",
+			[]string{"collector"}, constLabels,
+		),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "scrape",
+			Name:        "errors_total",
+			Help:        "Number of failed scrapes of the daemon by the phase they failed in.",
+			ConstLabels: constLabels,
+		}, []string{"phase"}),
+		shared: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "scrape",
+			Name:        "shared_total",
+			Help:        "Number of scrapes served from a fetch shared with a concurrent scrape.",
+			ConstLabels: constLabels,
+		}),
+		missing: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "exporter",
+			Name:        "missing_attributes_total",
+			Help:        "Number of times an expected attribute was absent from the daemon's response or had an unexpected type.",
+			ConstLabels: constLabels,
+		}, []string{"bean", "attribute"}),
+	}
+	for _, phase := range []string{PhaseConnect, PhaseStatus, PhaseRead, PhaseDecode, PhaseTooLarge, PhaseCircuitOpen} {
+		m.errors.WithLabelValues(phase)
+	}
+	return m
+}
+
+// Describe sends the descriptors of the scrape metrics.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.up
+	ch <- m.duration
+	ch <- m.collectorDuration
+	ch <- m.collectorSuccess
+	m.errors.Describe(ch)
+	m.shared.Describe(ch)
+	m.missing.Describe(ch)
+}
+
+// Shared counts a scrape served from a shared fetch.
+func (m *Metrics) Shared() {
+	m.shared.Inc()
+}
+
+// MissingAttribute records that attribute of bean was absent or had an
+// unexpected type.
+func (m *Metrics) MissingAttribute(bean, attribute string) {
+	m.missing.WithLabelValues(bean, attribute).Inc()
+}
+
+// SendCollector sends the metrics of a run of the named collector that took
+// duration and failed with err, if not nil. They are sent along with the
+// metrics of the collector, so that they are cached and shared with them.
+func (m *Metrics) SendCollector(ch chan<- prometheus.Metric, collector string, duration time.Duration, err error) {
+	success := 1.0
+	if err != nil {
+		success = 0
+	}
+	ch <- prometheus.MustNewConstMetric(m.collectorDuration, prometheus.GaugeValue, duration.Seconds(), collector)
+	ch <- prometheus.MustNewConstMetric(m.collectorSuccess, prometheus.GaugeValue, success, collector)
+}
+
+// Collect records the outcome of a scrape that started at start and failed
+// with err, if not nil, and sends the scrape metrics.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric, start time.Time, err error) {
+	m.Record(err)
+	m.Send(ch, time.Since(start), err)
+}
+
+// Record counts err, if not nil, as a failed scrape.
+func (m *Metrics) Record(err error) {
+	if err == nil {
+		return
+	}
+	phase := "unknown"
+	if e, ok := err.(*Error); ok {
+		phase = e.Phase
+	}
+	m.errors.WithLabelValues(phase).Inc()
+}
+
+// Send sends the scrape metrics for a scrape that took duration and failed
+// with err, if not nil, without recording it.
+func (m *Metrics) Send(ch chan<- prometheus.Metric, duration time.Duration, err error) {
+	up := 1.0
+	if err != nil {
+		up = 0
+	}
+	ch <- prometheus.MustNewConstMetric(m.up, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(m.duration, prometheus.GaugeValue, duration.Seconds())
+	m.errors.Collect(ch)
+	m.shared.Collect(ch)
+	m.missing.Collect(ch)
+}
@@ -0,0 +1,125 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CollectFunc fetches from a daemon and sends the resulting metrics to ch,
+// giving up when ctx is done.
+type CollectFunc func(ctx context.Context, ch chan<- prometheus.Metric) error
+
+// Gather runs collect and returns the metrics it sent.
+func Gather(ctx context.Context, collect CollectFunc) ([]prometheus.Metric, error) {
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+	err := collect(ctx, ch)
+	close(ch)
+	return <-done, err
+}
+
+var errNotPolled = errors.New("not polled yet")
+
+// Poller collects from a daemon at a fixed interval in the background and
+// serves scrapes from the metrics of the last successful poll.
+type Poller struct {
+	collect     CollectFunc
+	metrics     *Metrics
+	interval    time.Duration
+	lastSuccess *prometheus.Desc
+	age         *prometheus.Desc
+
+	mtx      sync.RWMutex
+	cached   []prometheus.Metric
+	at       time.Time
+	err      error
+	duration time.Duration
+}
+
+// NewPoller returns a Poller that runs collect every interval and records
+// the outcome in metrics. The poll metrics carry the same constant labels as
+// metrics. Call Run to start polling.
+func NewPoller(namespace string, metrics *Metrics, interval time.Duration, collect CollectFunc) *Poller {
+	return &Poller{
+		collect:  collect,
+		metrics:  metrics,
+		interval: interval,
+		lastSuccess: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "poll", "last_success_timestamp_seconds"),
+			"Time of the last successful poll of the daemon.",
+			nil, metrics.constLabels,
+		),
+		age: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "poll", "cache_age_seconds"),
+			"Age of the cached metrics served from the last successful poll.",
+			nil, metrics.constLabels,
+		),
+		err: errNotPolled,
+	}
+}
+
+// Run polls until ctx is done.
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.poll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Poller) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, p.interval)
+	defer cancel()
+	start := time.Now()
+	metrics, err := Gather(ctx, p.collect)
+	duration := time.Since(start)
+	p.metrics.Record(err)
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.err = err
+	p.duration = duration
+	if err == nil {
+		p.cached = metrics
+		p.at = start
+	}
+}
+
+// Describe sends the descriptors of the poll metrics.
+func (p *Poller) Describe(ch chan<- *prometheus.Desc) {
+	ch <- p.lastSuccess
+	ch <- p.age
+}
+
+// Collect sends the cached metrics, the scrape metrics of the last poll and
+// the age of the cache.
+func (p *Poller) Collect(ch chan<- prometheus.Metric) {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	for _, m := range p.cached {
+		ch <- m
+	}
+	p.metrics.Send(ch, p.duration, p.err)
+	var lastSuccess float64
+	if !p.at.IsZero() {
+		lastSuccess = float64(p.at.UnixNano()) / 1e9
+		ch <- prometheus.MustNewConstMetric(p.age, prometheus.GaugeValue, time.Since(p.at).Seconds())
+	}
+	ch <- prometheus.MustNewConstMetric(p.lastSuccess, prometheus.GaugeValue, lastSuccess)
+}
@@ -0,0 +1,133 @@
+// Package scrape fetches JSON documents from Hadoop daemons and reports how
+// fetching them went.
+package scrape
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// Phases at which fetching a document can fail. They are the values of the
+// "phase" label of the scrape error counter.
+const (
+	PhaseConnect  = "connect"
+	PhaseStatus   = "http_status"
+	PhaseRead     = "read"
+	PhaseDecode   = "json_decode"
+	PhaseTooLarge = "response_too_large"
+	// PhaseCircuitOpen means the request was not sent because the daemon
+	// failed too often recently, see Transport.
+	PhaseCircuitOpen = "circuit_open"
+)
+
+// Error is a failed fetch together with the phase it failed in.
+type Error struct {
+	Phase string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Phase + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// StatusError is the underlying error of an *Error in PhaseStatus.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return e.URL + " returned " + e.Status
+}
+
+// Get fetches url with client and returns the response body, which the
+// caller must close. The request is abandoned when ctx is done. If maxSize
+// is positive, reading more than maxSize bytes of the body fails with an
+// *Error in PhaseTooLarge. Errors are of type *Error.
+func Get(ctx context.Context, client *http.Client, url string, maxSize int64) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, &Error{PhaseConnect, err}
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		if errors.Is(err, ErrCircuitOpen) {
+			return nil, &Error{PhaseCircuitOpen, err}
+		}
+		return nil, &Error{PhaseConnect, err}
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &Error{PhaseStatus, &StatusError{url, resp.StatusCode, resp.Status}}
+	}
+	if maxSize > 0 {
+		return &limitedBody{resp.Body, maxSize, maxSize}, nil
+	}
+	return resp.Body, nil
+}
+
+// limitedBody fails reads once more than limit bytes have been read.
+type limitedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, b.tooLarge()
+	}
+	// Read one byte more than allowed to tell a body of exactly the limit
+	// from a larger one.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, b.tooLarge()
+	}
+	return n, err
+}
+
+func (b *limitedBody) tooLarge() error {
+	return &Error{PhaseTooLarge, fmt.Errorf("response larger than %d bytes", b.limit)}
+}
+
+// ReadError wraps an error returned while reading a response body in an
+// *Error, keeping the phase if it already is one.
+func ReadError(err error) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return &Error{PhaseRead, err}
+}
+
+// JSON fetches url with client and decodes the JSON response body into v.
+// The request is abandoned when ctx is done. Errors are of type *Error.
+func JSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	body, err := Get(ctx, client, url, 0)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return ReadError(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return &Error{PhaseDecode, err}
+	}
+	return nil
+}
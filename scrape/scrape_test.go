@@ -0,0 +1,46 @@
+package scrape
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetMaxSize(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		maxSize  int64
+		tooLarge bool
+	}{
+		{0, false},
+		{100, false},
+		{99, true},
+	} {
+		r, err := Get(context.Background(), server.Client(), server.URL, tc.maxSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		r.Close()
+		if !tc.tooLarge {
+			if err != nil || string(got) != body {
+				t.Errorf("limit %d: got %d bytes and error %v, want the whole body", tc.maxSize, len(got), err)
+			}
+			continue
+		}
+		if e, ok := err.(*Error); !ok || e.Phase != PhaseTooLarge {
+			t.Errorf("limit %d: got error %v, want one in phase %s", tc.maxSize, err, PhaseTooLarge)
+		}
+		if int64(len(got)) > tc.maxSize+1 {
+			t.Errorf("limit %d: read %d bytes", tc.maxSize, len(got))
+		}
+	}
+}
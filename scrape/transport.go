@@ -0,0 +1,306 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrCircuitOpen is returned by Transport for requests to a daemon whose
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Options configure how daemons are reached.
+type Options struct {
+	// ConnectTimeout bounds establishing a connection.
+	ConnectTimeout time.Duration
+	// ReadTimeout bounds a whole request, including retries and reading
+	// the response.
+	ReadTimeout time.Duration
+	// Retries is the number of times a failed request is retried.
+	Retries int
+	// RetryBackoff is the base of the exponential backoff between retries.
+	// The actual wait is chosen at random up to the backoff.
+	RetryBackoff time.Duration
+	// BreakerFailures is the number of failed requests in a row after which
+	// a daemon is no longer asked. 0 disables the circuit breaker.
+	BreakerFailures int
+	// BreakerCooldown is how long the breaker stays open before a single
+	// request probes whether the daemon is back.
+	BreakerCooldown time.Duration
+}
+
+// RegisterFlags registers the command line flags setting o, describing them
+// for daemon, e.g. "NameNode".
+func (o *Options) RegisterFlags(fs *flag.FlagSet, daemon string) {
+	fs.DurationVar(&o.ConnectTimeout, "upstream.connect-timeout", 5*time.Second, "Timeout for connecting to the "+daemon+".")
+	fs.DurationVar(&o.ReadTimeout, "upstream.read-timeout", 10*time.Second, "Timeout for a request to the "+daemon+", including retries and reading the response. Shortened to the scrape timeout announced by Prometheus.")
+	fs.IntVar(&o.Retries, "upstream.retries", 2, "Number of times a failed request to the "+daemon+" is retried within the timeout.")
+	fs.DurationVar(&o.RetryBackoff, "upstream.retry-backoff", 200*time.Millisecond, "Base of the jittered exponential backoff between retries.")
+	fs.IntVar(&o.BreakerFailures, "upstream.breaker-failures", 5, "Number of failed requests in a row after which the "+daemon+" is no longer asked until the cooldown has passed. 0 disables the circuit breaker.")
+	fs.DurationVar(&o.BreakerCooldown, "upstream.breaker-cooldown", 30*time.Second, "Time after which a single request probes whether the "+daemon+" is back.")
+}
+
+// Transport is an http.RoundTripper for requests to daemons. It keeps
+// connections alive between scrapes, retries failed requests with jittered
+// backoff as long as the request's deadline allows, and has a circuit
+// breaker per target host that stops sending requests to a daemon after
+// repeated failures. The failed requests of a scrape, see WithScrape, count
+// as one failure.
+//
+// Transport is a prometheus.Collector exporting the state of the breakers
+// and the number of retries.
+type Transport struct {
+	options   Options
+	transport http.RoundTripper
+
+	state   *prometheus.Desc
+	retries *prometheus.CounterVec
+
+	mtx      sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewTransport returns a Transport configured by o whose metrics are
+// prefixed with namespace and carry constLabels.
+func NewTransport(namespace string, constLabels prometheus.Labels, o Options) *Transport {
+	return &Transport{
+		options: o,
+		transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   o.ConnectTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   o.ConnectTimeout,
+			ExpectContinueTimeout: time.Second,
+		},
+		state: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "upstream", "circuit_breaker_state"),
+			"State of the circuit breaker of the target: 0 closed, 1 open, 2 half-open.",
+			[]string{"target"}, constLabels,
+		),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   "upstream",
+			Name:        "retries_total",
+			Help:        "Number of retried requests to the target.",
+			ConstLabels: constLabels,
+		}, []string{"target"}),
+		breakers: map[string]*breaker{},
+	}
+}
+
+// Client returns an HTTP client using t whose requests time out after
+// ReadTimeout.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{
+		Transport: t,
+		Timeout:   t.options.ReadTimeout,
+	}
+}
+
+// CloseIdleConnections closes the connections kept alive by t, e.g. before
+// discarding it.
+func (t *Transport) CloseIdleConnections() {
+	if tr, ok := t.transport.(*http.Transport); ok {
+		tr.CloseIdleConnections()
+	}
+}
+
+// scrapeKey is the context key of the scrape a request belongs to.
+type scrapeKey struct{}
+
+// WithScrape returns a context for the requests of a single scrape of a
+// daemon, e.g. those of its sub-collectors sent in parallel. The circuit
+// breakers of Transport count them as one failure if several fail, so that
+// they open after failed scrapes rather than failed requests.
+func WithScrape(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scrapeKey{}, new(int))
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := t.breaker(req.URL.Host)
+	if !b.allow(time.Now(), t.options.BreakerCooldown) {
+		return nil, ErrCircuitOpen
+	}
+	resp, err := t.roundTripWithRetries(req)
+	b.done(err == nil && resp.StatusCode < 500, req.Context().Value(scrapeKey{}), t.options.BreakerFailures, time.Now())
+	return resp, err
+}
+
+// roundTripWithRetries sends req until it gets a response that is not a
+// server error, the retries are used up or the next attempt would end after
+// the request's deadline.
+func (t *Transport) roundTripWithRetries(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		resp, err := t.transport.RoundTrip(req)
+		if err == nil && resp.StatusCode < 500 || attempt >= t.options.Retries || ctx.Err() != nil {
+			return resp, err
+		}
+		wait := t.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		t.retries.WithLabelValues(req.URL.Host).Inc()
+	}
+}
+
+// backoff returns a random wait before retry number attempt+1.
+func (t *Transport) backoff(attempt int) time.Duration {
+	max := int64(t.options.RetryBackoff) << uint(attempt)
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(max))
+}
+
+func (t *Transport) breaker(target string) *breaker {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	b, ok := t.breakers[target]
+	if !ok {
+		b = &breaker{}
+		t.breakers[target] = b
+	}
+	return b
+}
+
+// Describe implements the prometheus.Collector interface.
+func (t *Transport) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.state
+	t.retries.Describe(ch)
+}
+
+// Collect implements the prometheus.Collector interface.
+func (t *Transport) Collect(ch chan<- prometheus.Metric) {
+	t.mtx.Lock()
+	for target, b := range t.breakers {
+		ch <- prometheus.MustNewConstMetric(t.state, prometheus.GaugeValue, float64(b.current()), target)
+	}
+	t.mtx.Unlock()
+	t.retries.Collect(ch)
+}
+
+// CollectContext implements the ContextCollector interface, so that a
+// Transport can be served by DynamicHandler along with the collectors using
+// it.
+func (t *Transport) CollectContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	t.Collect(ch)
+}
+
+// Circuit breaker states, as exported.
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is the circuit breaker of one target. While closed, requests
+// pass. After enough failures in a row it opens and rejects requests until
+// the cooldown has passed, then lets a single probe through in the half-open
+// state. The probe closes the breaker if it succeeds and opens it again if
+// not.
+type breaker struct {
+	mtx      sync.Mutex
+	state    int
+	failures int
+	openedAt time.Time
+	// scrape is the scrape of the last failure counted, if any.
+	scrape interface{}
+}
+
+func (b *breaker) allow(now time.Time, cooldown time.Duration) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if now.Sub(b.openedAt) < cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// A probe is in flight.
+		return false
+	}
+	return true
+}
+
+// done records the outcome of a request of scrape, which is nil for
+// requests not made with WithScrape.
+func (b *breaker) done(ok bool, scrape interface{}, threshold int, now time.Time) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if ok {
+		b.state = breakerClosed
+		b.failures = 0
+		b.scrape = nil
+		return
+	}
+	if scrape != nil && scrape == b.scrape && b.state != breakerHalfOpen {
+		// The scrape already failed.
+		return
+	}
+	b.scrape = scrape
+	b.failures++
+	if threshold > 0 && (b.state == breakerHalfOpen || b.failures >= threshold) {
+		b.state = breakerOpen
+		b.openedAt = now
+	}
+}
+
+func (b *breaker) current() int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.state
+}
+
+// WithBasicAuth returns a copy of client that sends the credentials with
+// every request.
+func WithBasicAuth(client *http.Client, username, password string) *http.Client {
+	c := *client
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c.Transport = basicAuth{next, username, password}
+	return &c
+}
+
+type basicAuth struct {
+	next               http.RoundTripper
+	username, password string
+}
+
+func (a basicAuth) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it was given.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.SetBasicAuth(a.username, a.password)
+	return a.next.RoundTrip(r)
+}
@@ -0,0 +1,193 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// newFailingServer returns a server answering 503 to the first failures
+// requests, or to all if failures is negative, and the number of requests
+// it got.
+func newFailingServer(failures int64) (*httptest.Server, *int64) {
+	var requests int64
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&requests, 1)
+		if failures < 0 || n <= failures {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("{}"))
+	})), &requests
+}
+
+func TestTransportRetries(t *testing.T) {
+	server, requests := newFailingServer(2)
+	defer server.Close()
+	transport := NewTransport("test", nil, Options{
+		ReadTimeout:  time.Second,
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+	})
+
+	resp, err := transport.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || *requests != 3 {
+		t.Errorf("got status %d after %d requests, want 200 after 3", resp.StatusCode, *requests)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(transport)
+	metrics := httptest.NewServer(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	defer metrics.Close()
+	body := get(t, metrics.URL)
+	u, _ := url.Parse(server.URL)
+	for _, want := range []string{
+		`test_upstream_retries_total{target="` + u.Host + `"} 2`,
+		`test_upstream_circuit_breaker_state{target="` + u.Host + `"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s:\n%s", want, body)
+		}
+	}
+}
+
+// TestTransportBreaker opens the breaker after two failed scrapes of six
+// parallel requests each, and closes it again once the daemon is back.
+func TestTransportBreaker(t *testing.T) {
+	var fail int32 = 1
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	transport := NewTransport("test", nil, Options{
+		ReadTimeout:     time.Second,
+		BreakerFailures: 2,
+		BreakerCooldown: 50 * time.Millisecond,
+	})
+	client := transport.Client()
+	scrape := func() (rejected int) {
+		ctx := WithScrape(context.Background())
+		var mtx sync.Mutex
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req, _ := http.NewRequest("GET", server.URL, nil)
+				resp, err := client.Do(req.WithContext(ctx))
+				if err == nil {
+					resp.Body.Close()
+					return
+				}
+				if errors.Is(err, ErrCircuitOpen) {
+					mtx.Lock()
+					rejected++
+					mtx.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		return rejected
+	}
+
+	for i, want := range []int{breakerClosed, breakerOpen} {
+		if rejected := scrape(); rejected != 0 {
+			t.Errorf("scrape %d: %d requests rejected", i+1, rejected)
+		}
+		if state := transport.breaker(u.Host).current(); state != want {
+			t.Errorf("scrape %d: breaker state %d, want %d", i+1, state, want)
+		}
+	}
+	sent := atomic.LoadInt64(&requests)
+	if rejected := scrape(); rejected != 6 {
+		t.Errorf("%d requests rejected by the open breaker, want 6", rejected)
+	}
+	if n := atomic.LoadInt64(&requests); n != sent {
+		t.Errorf("%d requests sent while the breaker was open", n-sent)
+	}
+
+	atomic.StoreInt32(&fail, 0)
+	time.Sleep(60 * time.Millisecond)
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("probe after the cooldown: %s", err)
+	}
+	resp.Body.Close()
+	if state := transport.breaker(u.Host).current(); state != breakerClosed {
+		t.Errorf("breaker state %d after a successful probe, want closed", state)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	b := &breaker{}
+	a, c := new(int), new(int)
+	for i, step := range []struct {
+		ok     bool
+		scrape interface{}
+		want   int
+	}{
+		{false, a, breakerClosed},
+		// Further failures of the same scrape do not count.
+		{false, a, breakerClosed},
+		{true, nil, breakerClosed},
+		{false, a, breakerClosed},
+		{false, c, breakerOpen},
+	} {
+		b.done(step.ok, step.scrape, 2, now)
+		if state := b.current(); state != step.want {
+			t.Errorf("step %d: state %d, want %d", i+1, state, step.want)
+		}
+	}
+	if b.allow(now.Add(time.Second), time.Minute) {
+		t.Error("open breaker allowed a request before the cooldown")
+	}
+	if !b.allow(now.Add(time.Minute), time.Minute) || b.current() != breakerHalfOpen {
+		t.Error("breaker did not let a probe through after the cooldown")
+	}
+	if b.allow(now.Add(time.Minute), time.Minute) {
+		t.Error("half-open breaker allowed a second request")
+	}
+	// A failed probe opens the breaker again, even for a scrape that
+	// already failed.
+	b.done(false, c, 2, now.Add(time.Minute))
+	if b.current() != breakerOpen {
+		t.Error("failed probe did not open the breaker")
+	}
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}